@@ -0,0 +1,154 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// deltaBlockSize is the number of adjacent deltas packed with the
+// same bit width by CompressionBlockDelta.
+const deltaBlockSize = 64
+
+func newBlockCompressor(c cptvframe.CameraSpec) *blockCompressor {
+	elems := c.ResX() * c.ResY()
+	outBuf := new(bytes.Buffer)
+	outBuf.Grow(2*elems + elems/deltaBlockSize + 1)
+	return &blockCompressor{
+		frameDelta: make([]int32, elems),
+		adjDeltas:  make([]int32, elems-1),
+		outBuf:     outBuf,
+		prevFrame:  cptvframe.NewFrame(c),
+	}
+}
+
+// blockCompressor implements CompressionBlockDelta. It generates the
+// same adjacent deltas as Compressor but chooses the bit width for
+// each block of deltaBlockSize deltas, so that a few large deltas
+// (e.g. at the edges of a warm animal) only increase the size of the
+// blocks they appear in.
+type blockCompressor struct {
+	frameDelta []int32
+	adjDeltas  []int32
+	outBuf     *bytes.Buffer
+	prevFrame  *cptvframe.Frame
+}
+
+// Next compresses the next frame. The returned bit width is always 0
+// as each block records its own bit width.
+func (c *blockCompressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
+	snakeDeltas(curr, c.prevFrame, c.frameDelta)
+	for i := range c.adjDeltas {
+		c.adjDeltas[i] = c.frameDelta[i+1] - c.frameDelta[i]
+	}
+
+	c.outBuf.Reset()
+	binary.Write(c.outBuf, binary.LittleEndian, c.frameDelta[0])
+	for start := 0; start < len(c.adjDeltas); start += deltaBlockSize {
+		end := start + deltaBlockSize
+		if end > len(c.adjDeltas) {
+			end = len(c.adjDeltas)
+		}
+		block := c.adjDeltas[start:end]
+		width := blockWidth(block)
+		c.outBuf.WriteByte(width)
+		if width > 0 {
+			PackBits(width, block, c.outBuf)
+		}
+	}
+	return 0, c.outBuf.Bytes()
+}
+
+func (c *blockCompressor) Reset() {
+	clearFrame(c.prevFrame)
+}
+
+// blockWidth returns the bit width required to pack the deltas in
+// block, or 0 if they are all zero.
+func blockWidth(block []int32) uint8 {
+	var maxD uint32
+	for _, d := range block {
+		if absD := abs(d); absD > maxD {
+			maxD = absD
+		}
+	}
+	if maxD == 0 {
+		return 0
+	}
+	return numBits(maxD) + 1 // add 1 to allow for sign bit
+}
+
+func newBlockDecompressor(c cptvframe.CameraSpec) *blockDecompressor {
+	return &blockDecompressor{
+		deltas:    make([]int32, c.ResX()*c.ResY()),
+		prevFrame: cptvframe.NewFrame(c),
+	}
+}
+
+// blockDecompressor implements CompressionBlockDelta.
+type blockDecompressor struct {
+	deltas    []int32
+	prevFrame *cptvframe.Frame
+}
+
+func (d *blockDecompressor) Next(_ uint8, compressed ByteReaderReader, out *cptvframe.Frame) error {
+	var v int32
+	if err := binary.Read(compressed, binary.LittleEndian, &v); err != nil {
+		return err
+	}
+	d.deltas[0] = v
+	for start := 1; start < len(d.deltas); start += deltaBlockSize {
+		end := start + deltaBlockSize
+		if end > len(d.deltas) {
+			end = len(d.deltas)
+		}
+		width, err := compressed.ReadByte()
+		if err != nil {
+			return err
+		}
+		if width > 32 {
+			return fmt.Errorf("invalid bit width %d for block", width)
+		}
+		if width == 0 {
+			for i := start; i < end; i++ {
+				d.deltas[i] = v
+			}
+			continue
+		}
+		unpacker := NewBitUnpacker(width, compressed)
+		for i := start; i < end; i++ {
+			dv, err := unpacker.Next()
+			if err != nil {
+				return err
+			}
+			v += dv
+			d.deltas[i] = v
+		}
+	}
+	unsnakeDeltas(d.deltas, d.prevFrame, out)
+	return nil
+}
+
+func (d *blockDecompressor) Reset() {
+	clearFrame(d.prevFrame)
+}
+
+func (d *blockDecompressor) Reference() *cptvframe.Frame {
+	return d.prevFrame
+}
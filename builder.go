@@ -15,7 +15,11 @@
 package cptv
 
 import (
+	"bytes"
 	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 )
 
@@ -23,22 +27,75 @@ import (
 // compressed CPTV file to the provided Writer.
 func NewBuilder(w io.Writer) *Builder {
 	return &Builder{
-		w: gzip.NewWriter(w),
+		w:       gzip.NewWriter(w),
+		out:     w,
+		version: version,
 	}
 }
 
 // Builder handles the low-level construction of CPTV sections and
 // fields. See Writer for a higher-level interface.
 type Builder struct {
-	w *gzip.Writer
+	w       *gzip.Writer
+	out     io.Writer
+	version byte
+
+	// hash, if set, is updated with the uncompressed CPTV stream.
+	hash hash.Hash
+}
+
+func (b *Builder) write(p []byte) (int, error) {
+	if b.hash != nil {
+		b.hash.Write(p)
+	}
+	return b.w.Write(p)
+}
+
+// errLongFrameFields is returned when a frame has long fields but
+// the header has already been written without them.
+var errLongFrameFields = errors.New("frame fields longer than 255 bytes require long fields to be enabled before the header is written")
+
+// errKeyframeVersion is returned when a keyframe is written after the
+// header has been written without keyframes being enabled.
+var errKeyframeVersion = errors.New("keyframes must be enabled before the header is written")
+
+// EnableLongFields allows fields longer than 255 bytes to be written
+// in any section. This requires CPTV version 3, which older readers
+// don't support, so it is otherwise only used if the header contains
+// long fields. It must be called before WriteHeader.
+func (b *Builder) EnableLongFields() {
+	b.requireVersion(longFieldsVersion)
+}
+
+// requireVersion makes sure that at least version v of CPTV is
+// written. It must be called before WriteHeader.
+func (b *Builder) requireVersion(v byte) {
+	if b.version < v {
+		b.version = v
+	}
+}
+
+// fieldBytes returns the encoded fields of f for the version being
+// written.
+func (b *Builder) fieldBytes(f *FieldWriter) ([]byte, int, error) {
+	if f.fieldCount > maxFieldCount {
+		return nil, 0, fmt.Errorf("%d fields: %w", f.fieldCount, ErrTooManyFields)
+	}
+	return f.encode(b.version >= longFieldsVersion), f.fieldCount, nil
 }
 
 // WriteHeader writes a CPTV header to the current Writer
 func (b *Builder) WriteHeader(f *FieldWriter) error {
-	fieldData, numFields := f.Bytes()
-	_, err := b.w.Write(append(
+	if f.long {
+		b.EnableLongFields()
+	}
+	fieldData, numFields, err := b.fieldBytes(f)
+	if err != nil {
+		return err
+	}
+	_, err = b.write(append(
 		[]byte(magic),
-		version,
+		b.version,
 		HeaderSection,
 		byte(numFields),
 	))
@@ -46,27 +103,49 @@ func (b *Builder) WriteHeader(f *FieldWriter) error {
 		return err
 	}
 
-	_, err = b.w.Write(fieldData)
+	_, err = b.write(fieldData)
 	return err
 }
 
 // WriteFrame writes a CPTV frame to the current Writer
 func (b *Builder) WriteFrame(f *FieldWriter, frameData []byte) error {
+	if f.long && b.version < longFieldsVersion {
+		return errLongFrameFields
+	}
+
 	// Frame header
-	fieldData, numFields := f.Bytes()
-	_, err := b.w.Write([]byte{FrameSection, byte(numFields)})
+	fieldData, numFields, err := b.fieldBytes(f)
+	if err != nil {
+		return err
+	}
+	_, err = b.write([]byte{FrameSection, byte(numFields)})
 	if err != nil {
 		return err
 	}
 
 	// Frame fields
-	_, err = b.w.Write(fieldData)
+	_, err = b.write(fieldData)
 	if err != nil {
 		return err
 	}
 
 	// Frame thermal data
-	_, err = b.w.Write(frameData)
+	_, err = b.write(frameData)
+	return err
+}
+
+// WriteSection writes a section consisting only of fields, such as
+// the signature section. Such sections may only follow the frames.
+func (b *Builder) WriteSection(code byte, f *FieldWriter) error {
+	fieldData, numFields, err := b.fieldBytes(f)
+	if err != nil {
+		return err
+	}
+	_, err = b.write([]byte{code, byte(numFields)})
+	if err != nil {
+		return err
+	}
+	_, err = b.write(fieldData)
 	return err
 }
 
@@ -77,3 +156,41 @@ func (b *Builder) Close() error {
 	}
 	return b.w.Close()
 }
+
+// WriteFooter writes a CPTV footer section. The footer is written as
+// a separate gzip member after the main CPTV stream so that it can be
+// found at a fixed offset from the end of the file (see Probe). Close
+// must be called before WriteFooter.
+func (b *Builder) WriteFooter(f *FieldWriter) error {
+	footer, err := encodeFooter(f)
+	if err != nil {
+		return err
+	}
+	_, err = b.out.Write(footer)
+	return err
+}
+
+// encodeFooter returns a footer section as a complete gzip
+// member. The footer is stored without compression so that the size
+// of the member depends only on the size of the fields.
+func encodeFooter(f *FieldWriter) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	gw, err := gzip.NewWriterLevel(buf, gzip.NoCompression)
+	if err != nil {
+		return nil, err
+	}
+	fieldData, numFields := f.Bytes()
+	if numFields > maxFieldCount {
+		return nil, fmt.Errorf("%d fields: %w", numFields, ErrTooManyFields)
+	}
+	if _, err := gw.Write([]byte{FooterSection, byte(numFields)}); err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(fieldData); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
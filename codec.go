@@ -0,0 +1,220 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// Compression schemes, as recorded in the Compression header field.
+const (
+	// CompressionRaw stores each pixel as a little-endian uint16.
+	CompressionRaw byte = 0
+	// CompressionDelta stores the difference between successive
+	// frames, "snaked" across the rows, as bit-packed adjacent
+	// deltas. See Compressor.
+	CompressionDelta byte = 1
+	// CompressionRice stores the same adjacent deltas as
+	// CompressionDelta using adaptive Rice coding.
+	CompressionRice byte = 2
+	// CompressionBlockDelta stores the same adjacent deltas as
+	// CompressionDelta, bit-packed in blocks which each have their
+	// own bit width.
+	CompressionBlockDelta byte = 3
+	// CompressionMED stores the difference between successive frames
+	// as Rice coded errors from a prediction made using each pixel's
+	// left, upper and upper-left neighbours.
+	CompressionMED byte = 4
+)
+
+// FrameCompressor compresses successive frames of a recording.
+type FrameCompressor interface {
+	// Next compresses the next frame of the recording, returning the
+	// bit width to record for the frame and the compressed frame
+	// data. The returned data is only valid until the next call to
+	// Next.
+	Next(curr *cptvframe.Frame) (uint8, []byte)
+
+	// Reset clears any state carried between frames so that the next
+	// frame can be decompressed independently (a keyframe).
+	Reset()
+}
+
+// FrameDecompressor decompresses successive frames of a recording.
+type FrameDecompressor interface {
+	// Next decompresses the next frame of the recording into out,
+	// using the bit width recorded for the frame.
+	Next(bitWidth uint8, compressed ByteReaderReader, out *cptvframe.Frame) error
+
+	// Reset clears any state carried between frames. It is called
+	// before decompressing a keyframe.
+	Reset()
+
+	// Reference returns the frame which the next frame will be
+	// decompressed relative to, or nil if frames are decompressed
+	// independently. Changing the returned frame changes the state
+	// of the FrameDecompressor.
+	Reference() *cptvframe.Frame
+}
+
+// Codec creates the compressors and decompressors for a compression
+// scheme.
+type Codec struct {
+	NewCompressor   func(c cptvframe.CameraSpec) FrameCompressor
+	NewDecompressor func(c cptvframe.CameraSpec) FrameDecompressor
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		CompressionRaw: {
+			NewCompressor: func(c cptvframe.CameraSpec) FrameCompressor {
+				return newRawCompressor(c)
+			},
+			NewDecompressor: func(c cptvframe.CameraSpec) FrameDecompressor {
+				return newRawDecompressor(c)
+			},
+		},
+		CompressionDelta: {
+			NewCompressor: func(c cptvframe.CameraSpec) FrameCompressor {
+				return NewCompressor(c)
+			},
+			NewDecompressor: func(c cptvframe.CameraSpec) FrameDecompressor {
+				return NewDecompressor(c)
+			},
+		},
+		CompressionRice: {
+			NewCompressor: func(c cptvframe.CameraSpec) FrameCompressor {
+				return newRiceCompressor(c)
+			},
+			NewDecompressor: func(c cptvframe.CameraSpec) FrameDecompressor {
+				return newRiceDecompressor(c)
+			},
+		},
+		CompressionBlockDelta: {
+			NewCompressor: func(c cptvframe.CameraSpec) FrameCompressor {
+				return newBlockCompressor(c)
+			},
+			NewDecompressor: func(c cptvframe.CameraSpec) FrameDecompressor {
+				return newBlockDecompressor(c)
+			},
+		},
+		CompressionMED: {
+			NewCompressor: func(c cptvframe.CameraSpec) FrameCompressor {
+				return newMEDCompressor(c)
+			},
+			NewDecompressor: func(c cptvframe.CameraSpec) FrameDecompressor {
+				return newMEDDecompressor(c)
+			},
+		},
+	}
+)
+
+// RegisterCodec makes a compression scheme available for writing and
+// reading CPTV files. Any existing codec for the scheme is replaced.
+func RegisterCodec(scheme byte, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[scheme] = codec
+}
+
+func lookupCodec(scheme byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[scheme]
+	if !ok {
+		return Codec{}, fmt.Errorf("unsupported compression scheme %d", scheme)
+	}
+	return codec, nil
+}
+
+// compressionScheme returns the compression scheme used by a CPTV
+// file from its header fields. Files without a Compression field are
+// assumed to use CompressionDelta, the only scheme available when the
+// field was introduced.
+func compressionScheme(header Fields) byte {
+	scheme, err := header.Uint8(Compression)
+	if err != nil {
+		return CompressionDelta
+	}
+	return scheme
+}
+
+func newRawCompressor(c cptvframe.CameraSpec) *rawCompressor {
+	return &rawCompressor{
+		out: make([]byte, 2*c.ResX()*c.ResY()),
+	}
+}
+
+// rawCompressor implements CompressionRaw.
+type rawCompressor struct {
+	out []byte
+}
+
+func (c *rawCompressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
+	i := 0
+	for _, row := range curr.Pix {
+		for _, v := range row {
+			binary.LittleEndian.PutUint16(c.out[i:], v)
+			i += 2
+		}
+	}
+	return 16, c.out
+}
+
+func (c *rawCompressor) Reset() {}
+
+func newRawDecompressor(c cptvframe.CameraSpec) *rawDecompressor {
+	return &rawDecompressor{
+		buf: make([]byte, 2*c.ResX()),
+	}
+}
+
+// rawDecompressor implements CompressionRaw. Pixels may be stored
+// with either 8 or 16 bits.
+type rawDecompressor struct {
+	buf []byte
+}
+
+func (d *rawDecompressor) Next(bitWidth uint8, compressed ByteReaderReader, out *cptvframe.Frame) error {
+	if bitWidth != 8 && bitWidth != 16 {
+		return fmt.Errorf("unsupported bit width %d for uncompressed frame", bitWidth)
+	}
+	for _, row := range out.Pix {
+		buf := d.buf[:len(row)*int(bitWidth)/8]
+		if _, err := io.ReadFull(compressed, buf); err != nil {
+			return err
+		}
+		for x := range row {
+			if bitWidth == 8 {
+				row[x] = uint16(buf[x])
+			} else {
+				row[x] = binary.LittleEndian.Uint16(buf[2*x:])
+			}
+		}
+	}
+	return nil
+}
+
+func (d *rawDecompressor) Reset() {}
+
+func (d *rawDecompressor) Reference() *cptvframe.Frame {
+	return nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, scheme := range []byte{CompressionRaw, CompressionDelta, CompressionRice, CompressionBlockDelta, CompressionMED} {
+		testCodecRoundTrip(t, scheme)
+	}
+}
+
+func testCodecRoundTrip(t *testing.T, scheme byte) {
+	camera := new(TestCamera)
+	frames := make([]*cptvframe.Frame, 40)
+	frame := makeTestFrame(camera)
+	for i := range frames {
+		frame = makeOffsetFrame(camera, frame)
+		frame.Pix[i%camera.ResY()][i] = 0xffff
+		frames[i] = frame
+	}
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera, WithCompression(scheme))
+	require.NoError(t, w.WriteHeader(Header{}))
+	for _, frame := range frames {
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(cptvBytes.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, scheme, compressionScheme(r.header))
+	// Version 2 readers decode every scheme as delta compression.
+	if scheme == CompressionDelta {
+		assert.Equal(t, 2, r.Version())
+	} else {
+		assert.Equal(t, 3, r.Version(), "scheme %d", scheme)
+	}
+	frameD := r.EmptyFrame()
+	for i, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD), "scheme %d", scheme)
+		assert.Equal(t, frame, frameD, "scheme %d frame %d", scheme, i)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+
+	require.NoError(t, r.SeekFrame(37))
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frames[37], frameD, "scheme %d", scheme)
+}
+
+func TestUnknownCompression(t *testing.T) {
+	camera := new(TestCamera)
+
+	w := NewWriter(new(bytes.Buffer), camera, WithCompression(99))
+	assert.Error(t, w.WriteHeader(Header{}))
+	assert.Error(t, w.WriteFrame(makeTestFrame(camera)))
+
+	cptvBytes := new(bytes.Buffer)
+	b := NewBuilder(cptvBytes)
+	fields := NewFieldWriter()
+	fields.Uint32(XResolution, uint32(camera.ResX()))
+	fields.Uint32(YResolution, uint32(camera.ResY()))
+	fields.Uint8(Compression, 99)
+	require.NoError(t, b.WriteHeader(fields))
+	require.NoError(t, b.Close())
+
+	_, err := NewReader(cptvBytes)
+	assert.EqualError(t, err, "unsupported compression scheme 99")
+}
+
+func TestRawFrameSize(t *testing.T) {
+	camera := new(TestCamera)
+	comp := newRawCompressor(camera)
+	bitWidth, data := comp.Next(makeTestFrame(camera))
+	assert.Equal(t, uint8(16), bitWidth)
+	assert.Equal(t, 2*camera.ResX()*camera.ResY(), len(data))
+
+	// 8 bit frames can also be read.
+	frame := makeTestFrame(camera)
+	frame8 := make([]byte, camera.ResX()*camera.ResY())
+	for y, row := range frame.Pix {
+		for x := range row {
+			row[x] &= 0xff
+			frame8[y*camera.ResX()+x] = byte(row[x])
+		}
+	}
+	out := cptvframe.NewFrame(camera)
+	require.NoError(t, newRawDecompressor(camera).Next(8, bytes.NewReader(frame8), out))
+	assert.Equal(t, frame, out)
+}
+
+func TestRiceCoding(t *testing.T) {
+	values := []uint32{0, 1, 2, 3, 100, 7, 0, 0, 1 << 20, 0xffffffff, 5, 64, 12345}
+	buf := new(bytes.Buffer)
+	bw := bitWriter{w: buf}
+	var rs riceStats
+	rs.reset()
+	for _, v := range values {
+		bw.writeRice(v, rs.k())
+		rs.update(v)
+	}
+	bw.flush()
+
+	br := bitReader{r: bytes.NewReader(buf.Bytes())}
+	rs.reset()
+	for _, v := range values {
+		u, err := br.readRice(rs.k())
+		require.NoError(t, err)
+		assert.Equal(t, v, u)
+		rs.update(u)
+	}
+
+	for _, v := range []int32{0, 1, -1, 2, -2, 1 << 20, -(1 << 20), 1<<31 - 1, -1 << 31} {
+		assert.Equal(t, v, unzigzag(zigzag(v)))
+	}
+	assert.Equal(t, uint32(3), zigzag(-2))
+}
+
+func TestBlockDeltaWidths(t *testing.T) {
+	camera := new(TestCamera)
+	background := cptvframe.NewFrame(camera)
+	for _, row := range background.Pix {
+		for x := range row {
+			row[x] = 3000
+		}
+	}
+	// A single warm "animal" in a flat background.
+	frame := background.CreateCopy()
+	for y := 50; y < 60; y++ {
+		for x := 70; x < 80; x++ {
+			frame.Pix[y][x] = 3000 + uint16(x*y)
+		}
+	}
+
+	comp := newBlockCompressor(camera)
+	comp.Next(background)
+	_, data := comp.Next(frame)
+	blockCount := (camera.ResX()*camera.ResY() - 1 + deltaBlockSize - 1) / deltaBlockSize
+	// Only the blocks covering the animal (2 per row at most) need
+	// more than their width byte.
+	assert.True(t, len(data) <= 4+blockCount+20*deltaBlockSize*2, "got %d bytes", len(data))
+
+	deltaComp := NewCompressor(camera)
+	deltaComp.Next(background)
+	_, deltaData := deltaComp.Next(frame)
+	assert.True(t, len(data) < len(deltaData)/4)
+
+	decomp := newBlockDecompressor(camera)
+	_, bgData := newBlockCompressor(camera).Next(background)
+	out := cptvframe.NewFrame(camera)
+	require.NoError(t, decomp.Next(0, bytes.NewReader(bgData), out))
+	require.NoError(t, decomp.Next(0, bytes.NewReader(data), out))
+	assert.Equal(t, frame, out)
+}
+
+func TestMEDPredict(t *testing.T) {
+	// 3 columns, 2 rows.
+	vals := []int32{
+		6, 7, 2,
+		5, 0, 0,
+	}
+	assert.Equal(t, int32(0), medPredict(vals, 3, 0))
+	assert.Equal(t, int32(6), medPredict(vals, 3, 1)) // left on first row
+	assert.Equal(t, int32(6), medPredict(vals, 3, 3)) // up on first column
+
+	// 2x2 frames: c, b / a, predicted.
+	for _, tc := range []struct{ c, b, a, want int32 }{
+		{6, 7, 5, 6},  // a + b - c
+		{9, 7, 5, 5},  // c >= max(a, b): min(a, b)
+		{1, 7, 5, 7},  // c <= min(a, b): max(a, b)
+		{-3, 4, 4, 4}, // flat
+	} {
+		assert.Equal(t, tc.want, medPredict([]int32{tc.c, tc.b, tc.a, 0}, 2, 3), "%+v", tc)
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	for _, scheme := range benchmarkSchemes {
+		b.Run(schemeName(scheme), func(b *testing.B) {
+			frames, camera := loadV2Frames(b)
+			codec, err := lookupCodec(scheme)
+			require.NoError(b, err)
+			b.SetBytes(int64(len(frames) * camera.ResX() * camera.ResY() * 2))
+			b.ResetTimer()
+			var size int
+			for i := 0; i < b.N; i++ {
+				comp := codec.NewCompressor(camera)
+				size = 0
+				for _, frame := range frames {
+					_, data := comp.Next(frame)
+					size += len(data)
+				}
+			}
+			b.Logf("%.0f bytes/frame", float64(size)/float64(len(frames)))
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, scheme := range benchmarkSchemes {
+		b.Run(schemeName(scheme), func(b *testing.B) {
+			frames, camera := loadV2Frames(b)
+			codec, err := lookupCodec(scheme)
+			require.NoError(b, err)
+			var bitWidths []uint8
+			var compressed [][]byte
+			comp := codec.NewCompressor(camera)
+			for _, frame := range frames {
+				bitWidth, data := comp.Next(frame)
+				bitWidths = append(bitWidths, bitWidth)
+				compressed = append(compressed, append([]byte(nil), data...))
+			}
+			out := cptvframe.NewFrame(camera)
+			b.SetBytes(int64(len(frames) * camera.ResX() * camera.ResY() * 2))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				decomp := codec.NewDecompressor(camera)
+				for j := range compressed {
+					err := decomp.Next(bitWidths[j], bytes.NewReader(compressed[j]), out)
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+var benchmarkSchemes = []byte{CompressionRaw, CompressionDelta, CompressionRice, CompressionBlockDelta, CompressionMED}
+
+func schemeName(scheme byte) string {
+	switch scheme {
+	case CompressionRaw:
+		return "raw"
+	case CompressionDelta:
+		return "delta"
+	case CompressionRice:
+		return "rice"
+	case CompressionBlockDelta:
+		return "block"
+	case CompressionMED:
+		return "med"
+	}
+	return fmt.Sprint(scheme)
+}
+
+// loadV2Frames returns all the frames in v2.cptv.
+func loadV2Frames(tb testing.TB) ([]*cptvframe.Frame, cptvframe.CameraSpec) {
+	r, err := NewFileReader("v2.cptv")
+	require.NoError(tb, err)
+	defer r.Close()
+	var frames []*cptvframe.Frame
+	for {
+		frame := r.EmptyFrame()
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(tb, err)
+		frames = append(frames, frame)
+	}
+	return frames, r.header
+}
@@ -55,28 +55,7 @@ type Compressor struct {
 // IMPORTANT: The returned byte slice is reused and therefore is only
 // valid until the next call to Next.
 func (c *Compressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
-	// Generate the interframe delta.
-	// The output is written in a "snaked" fashion to avoid
-	// potentially greater deltas at the edges in the next stage.
-	var i int
-	for y := 0; y < c.rows; y++ {
-		i = y * c.cols
-		if y&1 == 1 {
-			i += c.cols - 1
-		}
-		for x := 0; x < c.cols; x++ {
-			c.frameDelta[i] = int32(curr.Pix[y][x]) - int32(c.prevFrame.Pix[y][x])
-			// Now that prevFrame[y][x] has been used, copy the value
-			// for the current frame in for the next call to Next().
-			// TODO: it might be fast to copy() rows separately.
-			c.prevFrame.Pix[y][x] = curr.Pix[y][x]
-			if y&1 == 0 {
-				i++
-			} else {
-				i--
-			}
-		}
-	}
+	snakeDeltas(curr, c.prevFrame, c.frameDelta)
 
 	// Now generate the adjacent "delta of deltas".
 	var maxD uint32
@@ -100,6 +79,57 @@ func (c *Compressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
 	return width, c.outBuf.Bytes()
 }
 
+// Reset clears the reference frame used by the Compressor so that the
+// next frame is compressed independently of the frames before it.
+func (c *Compressor) Reset() {
+	clearFrame(c.prevFrame)
+}
+
+// snakeDeltas generates the interframe delta between curr and prev,
+// writing it to out. prev is updated to hold curr.
+//
+// The output is written in a "snaked" fashion (every second row is
+// reversed) to avoid potentially greater deltas at the edges when
+// adjacent deltas are taken.
+func snakeDeltas(curr, prev *cptvframe.Frame, out []int32) {
+	var i int
+	for y, row := range curr.Pix {
+		cols := len(row)
+		i = y * cols
+		if y&1 == 1 {
+			i += cols - 1
+		}
+		for x := 0; x < cols; x++ {
+			out[i] = int32(row[x]) - int32(prev.Pix[y][x])
+			// Now that prev[y][x] has been used, copy the value
+			// for the current frame in for the next frame.
+			// TODO: it might be fast to copy() rows separately.
+			prev.Pix[y][x] = row[x]
+			if y&1 == 0 {
+				i++
+			} else {
+				i--
+			}
+		}
+	}
+}
+
+// unsnakeDeltas reverses snakeDeltas, adding the snaked interframe
+// deltas to prev to produce out. prev is updated to hold out.
+func unsnakeDeltas(deltas []int32, prev, out *cptvframe.Frame) {
+	for y, row := range out.Pix {
+		cols := len(row)
+		for x := 0; x < cols; x++ {
+			i := y*cols + x
+			if y&1 == 1 {
+				i = y*cols + cols - x - 1
+			}
+			row[x] = uint16(int32(prev.Pix[y][x]) + deltas[i])
+			prev.Pix[y][x] = row[x]
+		}
+	}
+}
+
 // NewDecompressor creates a new Decompressor.
 func NewDecompressor(c cptvframe.CameraSpec) *Decompressor {
 	decomp := &Decompressor{
@@ -171,6 +201,19 @@ func (d *Decompressor) Next(bitWidth uint8, compressed ByteReaderReader, out *cp
 	return nil
 }
 
+// Reset clears the reference frame used by the Decompressor. This
+// must be called before decompressing a frame which was compressed
+// after the Compressor was reset.
+func (d *Decompressor) Reset() {
+	clearFrame(d.prevFrame)
+}
+
+// Reference returns the frame which the next frame will be
+// decompressed relative to (the previous frame).
+func (d *Decompressor) Reference() *cptvframe.Frame {
+	return d.prevFrame
+}
+
 // PackBits takes a slice of signed integers and packs them into an
 // abitrary (smaller) bit width. The most significant bit is written
 // out first.
@@ -227,6 +270,14 @@ func (u *BitUnpacker) Next() (int32, error) {
 	return out, nil
 }
 
+func clearFrame(f *cptvframe.Frame) {
+	for _, row := range f.Pix {
+		for x := range row {
+			row[x] = 0
+		}
+	}
+}
+
 func abs(x int32) uint32 {
 	if x < 0 {
 		return uint32(-x)
@@ -18,8 +18,28 @@ const (
 	magic        = "CPTV"
 	version byte = 0x02
 
-	HeaderSection = 'H'
-	FrameSection  = 'F'
+	// longFieldsVersion is the CPTV version which introduced fields
+	// longer than 255 bytes. It is only written when needed.
+	longFieldsVersion byte = 0x03
+
+	// keyframesVersion is the CPTV version from which frames after
+	// the first may be keyframes. Older readers would decode the
+	// frames following a keyframe incorrectly. It is only written
+	// when needed.
+	keyframesVersion byte = 0x03
+
+	// compressionVersion is the CPTV version from which compression
+	// schemes other than CompressionDelta may be used. Older readers
+	// ignore the compression scheme and would decode the frames
+	// incorrectly. It is only written when needed.
+	compressionVersion byte = 0x03
+
+	maxVersion byte = longFieldsVersion
+
+	HeaderSection    = 'H'
+	FrameSection     = 'F'
+	FooterSection    = 'Z'
+	SignatureSection = 'S'
 
 	// Header field keys
 	Timestamp    byte = 'T'
@@ -48,4 +68,26 @@ const (
 	TempC           byte = 'a'
 	LastFFCTempC    byte = 'b'
 	BackgroundFrame byte = 'g'
+	Keyframe        byte = 'k'
+	FrameCount      byte = 'n'
+	FFCState        byte = 's'
+	FrameMean       byte = 'm'
+	FrameCRC        byte = 'r'
+
+	// UserFieldMin is the lowest header and frame field key available
+	// for application defined fields (see Header.Extra and
+	// cptvframe.Frame.Extra). Keys from UserFieldMin up will never be
+	// used by the CPTV specification.
+	UserFieldMin byte = 0x80
+
+	// Footer field keys
+	FooterFrames      byte = 'n'
+	FooterBackground  byte = 'g'
+	FooterFirstTimeOn byte = 't'
+	FooterLastTimeOn  byte = 'l'
+	FooterDuration    byte = 'd'
+
+	// Signature field keys
+	Signature          byte = 's'
+	SignaturePublicKey byte = 'p'
 )
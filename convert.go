@@ -0,0 +1,60 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import "io"
+
+// Convert writes the recording in r to w with its frames compressed
+// again using opts, for example to change the compression scheme or
+// add frame checksums. The compression scheme and long fields setting
+// of the recording are used unless opts overrides them, and frame
+// checksums and the footer are kept if the recording has them. The
+// header, including the background frame, is kept.
+//
+// As the frames are compressed again, keyframes are only written if
+// requested with WithKeyframeInterval and any signature is dropped;
+// use WithSigningKey to sign the new recording.
+func Convert(r io.Reader, w io.Writer, opts ...WriterOption) error {
+	reader, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	header, err := reader.Header()
+	if err != nil {
+		return err
+	}
+	writer := NewWriter(w, reader, append(copyOptions(reader), opts...)...)
+	keepSettings(writer, reader)
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	frame := reader.EmptyFrame()
+	for {
+		err := reader.ReadFrame(frame)
+		keepSettings(writer, reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if frame.Status.BackgroundFrame && header.BackgroundFrame != nil {
+			continue
+		}
+		if err := writer.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
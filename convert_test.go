@@ -0,0 +1,70 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTrimRecording(t, camera, Header{DeviceName: "nz42"}, true)
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Convert(bytes.NewReader(rec.bytes), out,
+		WithCompression(CompressionMED), WithFrameChecksums(), WithFooter()))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "nz42", r.DeviceName())
+	assert.Equal(t, CompressionMED, compressionScheme(r.header))
+	assertFrames(t, r, rec.frames)
+
+	result, err := Verify(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, len(rec.frames), result.Checksums)
+	summary, err := Probe(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, len(rec.frames), summary.FrameCount)
+}
+
+func TestConvertSettings(t *testing.T) {
+	camera := new(TestCamera)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	rec := writeTrimRecording(t, camera, Header{}, true,
+		WithFrameChecksums(), WithFooter(), WithSigningKey(priv))
+
+	// Frame checksums and the footer are kept but the signature isn't.
+	out := new(bytes.Buffer)
+	require.NoError(t, Convert(bytes.NewReader(rec.bytes), out))
+	result, err := Verify(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, len(rec.frames), result.Checksums)
+	summary, err := Probe(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, len(rec.frames), summary.FrameCount)
+	assert.Equal(t, ErrUnsigned, VerifySignature(bytes.NewReader(out.Bytes()), pub))
+
+	// The new recording can be signed again.
+	out.Reset()
+	require.NoError(t, Convert(bytes.NewReader(rec.bytes), out, WithSigningKey(priv)))
+	assert.NoError(t, VerifySignature(bytes.NewReader(out.Bytes()), pub))
+}
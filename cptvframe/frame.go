@@ -8,6 +8,16 @@ package cptvframe
 type Frame struct {
 	Pix    [][]uint16
 	Status Telemetry
+
+	// Extra holds application defined fields stored with the frame,
+	// keyed by field code.
+	Extra map[byte][]byte
+
+	// Reserved holds fields defined by a newer version of the CPTV
+	// specification which weren't understood when the frame was
+	// read, keyed by field code. They are written out again
+	// unchanged.
+	Reserved map[byte][]byte
 }
 
 // Creates a new frame sized for the provided camera implementation
@@ -34,7 +44,20 @@ func (fr *Frame) CreateCopy() *Frame {
 // Copy sets current frame as other frame
 func (fr *Frame) Copy(orig *Frame) {
 	fr.Status = orig.Status
+	fr.Extra = copyFields(orig.Extra)
+	fr.Reserved = copyFields(orig.Reserved)
 	for y, row := range orig.Pix {
 		copy(fr.Pix[y][:], row)
 	}
 }
+
+func copyFields(fields map[byte][]byte) map[byte][]byte {
+	if fields == nil {
+		return nil
+	}
+	out := make(map[byte][]byte, len(fields))
+	for code, data := range fields {
+		out[code] = data
+	}
+	return out
+}
@@ -40,6 +40,7 @@ func TestFrameCopy(t *testing.T) {
 	frame.Status.TimeOn = 10 * time.Second
 	frame.Status.FrameCount = 123
 	frame.Status.TempC = 23.1
+	frame.Extra = map[byte][]byte{0x80: []byte("trap 7")}
 
 	frame2 := NewFrame(camera)
 	frame2.Copy(frame)
@@ -50,4 +51,9 @@ func TestFrameCopy(t *testing.T) {
 	assert.Equal(t, 4, int(frame2.Pix[0][camera.ResX()-1]))
 	assert.Equal(t, 5, int(frame2.Pix[camera.ResY()-1][camera.ResX()-1]))
 	assert.Equal(t, frame.Status, frame2.Status)
+	assert.Equal(t, frame.Extra, frame2.Extra)
+
+	// The copy has its own Extra map.
+	frame2.Extra[0x81] = nil
+	assert.Len(t, frame.Extra, 1)
 }
@@ -0,0 +1,188 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+// Package cptvnpy exports CPTV recordings in NumPy's .npy and .npz
+// formats.
+package cptvnpy
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// WriteNPY writes the remaining frames of a recording to w as a .npy
+// file holding a uint16 array of shape (frames, ResY, ResX). The
+// background frame, if present, is included.
+//
+// Frames are written as they are read so the recording isn't held in
+// memory. The frames are counted first so the Reader must be able to
+// seek (see cptv.Reader.SeekFrame).
+func WriteNPY(w io.Writer, r *cptv.Reader) error {
+	count, err := countFrames(r)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeFrames(bw, r, count, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Telemetry array names used in .npz files written by WriteNPZ.
+const (
+	FramesArray          = "frames"
+	TimeOnArray          = "time_on"
+	LastFFCTimeArray     = "last_ffc_time"
+	TempCArray           = "temp_c"
+	LastFFCTempCArray    = "last_ffc_temp_c"
+	BackgroundFrameArray = "background_frame"
+)
+
+// WriteNPZ writes the remaining frames of a recording to w as a .npz
+// file (as written by numpy.savez_compressed). The frames are stored
+// as for WriteNPY in the "frames" array along with one array for each
+// of the frames' telemetry values:
+//
+//	time_on          uint32   time on in milliseconds
+//	last_ffc_time    uint32   last FFC time in milliseconds
+//	temp_c           float32  temperature in degrees C
+//	last_ffc_temp_c  float32  temperature at the last FFC
+//	background_frame bool     true for the background frame
+//
+// As with WriteNPY, the Reader must be able to seek.
+func WriteNPZ(w io.Writer, r *cptv.Reader) error {
+	count, err := countFrames(r)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	fw, err := zw.Create(FramesArray + ".npy")
+	if err != nil {
+		return err
+	}
+	var t telemetry
+	bw := bufio.NewWriter(fw)
+	if err := writeFrames(bw, r, count, &t); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	arrays := []struct {
+		name  string
+		descr string
+		data  interface{}
+	}{
+		{TimeOnArray, "<u4", t.timeOn},
+		{LastFFCTimeArray, "<u4", t.lastFFCTime},
+		{TempCArray, "<f4", t.tempC},
+		{LastFFCTempCArray, "<f4", t.lastFFCTempC},
+		{BackgroundFrameArray, "|b1", t.background},
+	}
+	for _, a := range arrays {
+		fw, err := zw.Create(a.name + ".npy")
+		if err != nil {
+			return err
+		}
+		if err := writeHeader(fw, a.descr, count); err != nil {
+			return err
+		}
+		if err := binary.Write(fw, binary.LittleEndian, a.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// telemetry collects the telemetry of each frame.
+type telemetry struct {
+	timeOn       []uint32
+	lastFFCTime  []uint32
+	tempC        []float32
+	lastFFCTempC []float32
+	background   []bool
+}
+
+func (t *telemetry) add(s *cptvframe.Telemetry) {
+	t.timeOn = append(t.timeOn, uint32(s.TimeOn.Milliseconds()))
+	t.lastFFCTime = append(t.lastFFCTime, uint32(s.LastFFCTime.Milliseconds()))
+	t.tempC = append(t.tempC, float32(s.TempC))
+	t.lastFFCTempC = append(t.lastFFCTempC, float32(s.LastFFCTempC))
+	t.background = append(t.background, s.BackgroundFrame)
+}
+
+// writeFrames writes count frames from r as a .npy array, adding
+// their telemetry to t if it isn't nil.
+func writeFrames(w io.Writer, r *cptv.Reader, count int, t *telemetry) error {
+	if err := writeHeader(w, "<u2", count, r.ResY(), r.ResX()); err != nil {
+		return err
+	}
+	frame := r.EmptyFrame()
+	row := make([]byte, 2*r.ResX())
+	for i := 0; i < count; i++ {
+		if err := r.ReadFrame(frame); err != nil {
+			return err
+		}
+		for _, pix := range frame.Pix {
+			for x, v := range pix {
+				binary.LittleEndian.PutUint16(row[2*x:], v)
+			}
+			if _, err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		if t != nil {
+			t.add(&frame.Status)
+		}
+	}
+	return nil
+}
+
+// writeHeader writes a version 1.0 .npy header for a C ordered array.
+func writeHeader(w io.Writer, descr string, shape ...int) error {
+	dims := make([]string, len(shape))
+	for i, n := range shape {
+		dims[i] = fmt.Sprint(n)
+	}
+	shapeStr := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeStr += ","
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shapeStr)
+
+	// The header is padded with spaces and terminated by a newline so
+	// that the data is aligned to 64 bytes.
+	const prefixLen = 10
+	total := prefixLen + len(dict) + 1
+	if pad := total % 64; pad != 0 {
+		dict += strings.Repeat(" ", 64-pad)
+	}
+	dict += "\n"
+
+	header := make([]byte, prefixLen, prefixLen+len(dict))
+	copy(header, "\x93NUMPY\x01\x00")
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(dict)))
+	_, err := w.Write(append(header, dict...))
+	return err
+}
+
+// countFrames returns the number of remaining frames in r without
+// changing its position.
+func countFrames(r *cptv.Reader) (int, error) {
+	start := r.Position()
+	count, err := r.FrameCount()
+	if err != nil {
+		return 0, err
+	}
+	return count, r.SeekFrame(start)
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvnpy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+type testCamera struct{}
+
+func (testCamera) ResX() int { return 4 }
+func (testCamera) ResY() int { return 3 }
+func (testCamera) FPS() int  { return 9 }
+
+func TestWriteNPY(t *testing.T) {
+	r := testRecording(t, 3)
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteNPY(buf, r))
+
+	data := buf.Bytes()
+	header, body := parseNPY(t, data)
+	assert.Equal(t, "{'descr': '<u2', 'fortran_order': False, 'shape': (4, 3, 4), }", header)
+	require.Len(t, body, 4*3*4*2)
+	// Frame 0 is the background frame.
+	assert.Equal(t, uint16(500), binary.LittleEndian.Uint16(body))
+	// Frame 2, row 1, column 3.
+	i := ((2*3+1)*4 + 3) * 2
+	assert.Equal(t, uint16(1000+100+7), binary.LittleEndian.Uint16(body[i:]))
+}
+
+func TestWriteNPZ(t *testing.T) {
+	r := testRecording(t, 3)
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteNPZ(buf, r))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	arrays := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		arrays[f.Name] = data
+	}
+	assert.Len(t, arrays, 6)
+
+	header, _ := parseNPY(t, arrays["frames.npy"])
+	assert.Equal(t, "{'descr': '<u2', 'fortran_order': False, 'shape': (4, 3, 4), }", header)
+
+	header, body := parseNPY(t, arrays["time_on.npy"])
+	assert.Equal(t, "{'descr': '<u4', 'fortran_order': False, 'shape': (4,), }", header)
+	timeOn := make([]uint32, 4)
+	require.NoError(t, binary.Read(bytes.NewReader(body), binary.LittleEndian, timeOn))
+	assert.Equal(t, []uint32{0, 10000, 10100, 10200}, timeOn)
+
+	header, body = parseNPY(t, arrays["temp_c.npy"])
+	assert.Equal(t, "{'descr': '<f4', 'fortran_order': False, 'shape': (4,), }", header)
+	tempC := make([]float32, 4)
+	require.NoError(t, binary.Read(bytes.NewReader(body), binary.LittleEndian, tempC))
+	assert.Equal(t, []float32{0, 20, 20.5, 21}, tempC)
+
+	header, body = parseNPY(t, arrays["background_frame.npy"])
+	assert.Equal(t, "{'descr': '|b1', 'fortran_order': False, 'shape': (4,), }", header)
+	assert.Equal(t, []byte{1, 0, 0, 0}, body)
+}
+
+// parseNPY checks the .npy header and returns the header dictionary
+// and the array data.
+func parseNPY(t *testing.T, data []byte) (string, []byte) {
+	require.True(t, bytes.HasPrefix(data, []byte("\x93NUMPY\x01\x00")))
+	n := int(binary.LittleEndian.Uint16(data[8:]))
+	assert.Equal(t, 0, (10+n)%64)
+	header := data[10 : 10+n]
+	assert.Equal(t, byte('\n'), header[n-1])
+	return string(bytes.TrimRight(header, " \n")), data[10+n:]
+}
+
+// testRecording returns a Reader for a recording with a background
+// frame and n frames.
+func testRecording(t *testing.T, n int) *cptv.Reader {
+	buf := new(bytes.Buffer)
+	w := cptv.NewWriter(buf, testCamera{})
+	background := cptvframe.NewFrame(testCamera{})
+	for _, row := range background.Pix {
+		for x := range row {
+			row[x] = 500
+		}
+	}
+	require.NoError(t, w.WriteHeader(cptv.Header{BackgroundFrame: background}))
+	for i := 0; i < n; i++ {
+		frame := cptvframe.NewFrame(testCamera{})
+		for y, row := range frame.Pix {
+			for x := range row {
+				row[x] = uint16(1000 + 100*i + y*4 + x)
+			}
+		}
+		frame.Status.TimeOn = 10*time.Second + time.Duration(i)*100*time.Millisecond
+		frame.Status.TempC = 20 + 0.5*float64(i)
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := cptv.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	return r
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// AVIOptions controls how a recording is converted to video. The zero
+// value uses the Ironbow colour map at the original resolution without
+// an overlay.
+type AVIOptions struct {
+	ColourMap *ColourMap
+
+	// Normaliser sets the range of values coloured. If nil, the range
+	// of the whole recording is used (see ClipRange).
+	Normaliser Normaliser
+
+	// Scale enlarges the frames by a whole number factor.
+	Scale int
+
+	// Overlay draws each frame's time on and temperature on the
+	// video.
+	Overlay bool
+
+	// Quality is the JPEG quality (1-100). The default is 90.
+	Quality int
+}
+
+// WriteAVI writes the remaining frames of a recording to w as a
+// Motion-JPEG AVI video, which can be played by most video
+// players. The background frame isn't included.
+//
+// w must be able to seek as the AVI headers are updated once all the
+// frames have been written. Unless a Normaliser is given, the frames
+// are read twice so the Reader must also be able to seek.
+func WriteAVI(w io.WriteSeeker, r *cptv.Reader, opts AVIOptions) error {
+	cmap := opts.ColourMap
+	if cmap == nil {
+		cmap = Ironbow
+	}
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	quality := opts.Quality
+	if quality == 0 {
+		quality = 90
+	}
+	n := opts.Normaliser
+	if n == nil {
+		clip, err := ClipRange(r)
+		if err != nil {
+			return err
+		}
+		n = clip
+	}
+
+	aw, err := newAVIWriter(w, r.ResX()*scale, r.ResY()*scale, r.FPS())
+	if err != nil {
+		return err
+	}
+	frame := r.EmptyFrame()
+	var paletted *image.Paletted
+	jpegBuf := new(bytes.Buffer)
+	for {
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if frame.Status.BackgroundFrame {
+			continue
+		}
+		if paletted == nil {
+			paletted = Render(frame, n, cmap)
+		} else {
+			RenderInto(paletted, frame, n)
+		}
+		img := Upscale(paletted, scale)
+		if opts.Overlay {
+			DrawTelemetry(img, &frame.Status, scale)
+		}
+		jpegBuf.Reset()
+		if err := jpeg.Encode(jpegBuf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		if err := aw.writeFrame(jpegBuf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return aw.close()
+}
+
+// aviWriter writes a single Motion-JPEG stream in an AVI (RIFF)
+// container. Space is left for the headers, which are written by
+// close once the number of frames is known.
+type aviWriter struct {
+	w             io.WriteSeeker
+	width, height int
+	fps           int
+
+	start   int64 // offset of the RIFF chunk
+	movi    int64 // offset of the "movi" list type
+	pos     int64 // current offset
+	index   []aviIndexEntry
+	maxSize int
+}
+
+type aviIndexEntry struct {
+	offset, size uint32
+}
+
+const (
+	aviKeyframe = 0x10 // AVIIF_KEYFRAME
+	aviHasIndex = 0x10 // AVIF_HASINDEX
+)
+
+func newAVIWriter(w io.WriteSeeker, width, height, fps int) (*aviWriter, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	aw := &aviWriter{
+		w:      w,
+		width:  width,
+		height: height,
+		fps:    fps,
+		start:  start,
+	}
+	// Write placeholder headers which are replaced by close.
+	if err := aw.write(aw.headers()); err != nil {
+		return nil, err
+	}
+	if err := aw.write([]byte("LIST\x00\x00\x00\x00movi")); err != nil {
+		return nil, err
+	}
+	aw.movi = aw.pos - 4
+	return aw, nil
+}
+
+func (aw *aviWriter) write(b []byte) error {
+	n, err := aw.w.Write(b)
+	aw.pos += int64(n)
+	return err
+}
+
+func (aw *aviWriter) writeFrame(data []byte) error {
+	if aw.pos-aw.start+int64(len(data)) > 1<<31 {
+		return errors.New("AVI file too large")
+	}
+	aw.index = append(aw.index, aviIndexEntry{
+		offset: uint32(aw.pos - aw.movi),
+		size:   uint32(len(data)),
+	})
+	if len(data) > aw.maxSize {
+		aw.maxSize = len(data)
+	}
+	if err := aw.write(chunk("00dc", data)); err != nil {
+		return err
+	}
+	if len(data)%2 == 1 {
+		return aw.write([]byte{0})
+	}
+	return nil
+}
+
+func (aw *aviWriter) close() error {
+	moviEnd := aw.pos
+	idx := new(bytes.Buffer)
+	for _, e := range aw.index {
+		idx.WriteString("00dc")
+		binary.Write(idx, binary.LittleEndian, []uint32{aviKeyframe, e.offset, e.size})
+	}
+	if err := aw.write(chunk("idx1", idx.Bytes())); err != nil {
+		return err
+	}
+	end := aw.pos
+
+	// Fill in the headers and chunk sizes.
+	if _, err := aw.w.Seek(aw.start, io.SeekStart); err != nil {
+		return err
+	}
+	headers := aw.headers()
+	binary.LittleEndian.PutUint32(headers[4:], uint32(end-aw.start-8))
+	if _, err := aw.w.Write(headers); err != nil {
+		return err
+	}
+	moviHeader := []byte("LIST\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(moviHeader[4:], uint32(moviEnd-aw.movi))
+	if _, err := aw.w.Write(moviHeader); err != nil {
+		return err
+	}
+	_, err := aw.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// headers returns the RIFF header and the "hdrl" list.
+func (aw *aviWriter) headers() []byte {
+	frames := uint32(len(aw.index))
+	width, height := uint32(aw.width), uint32(aw.height)
+	bufSize := uint32(aw.maxSize + 8)
+
+	avih := make([]byte, 56)
+	putUint32s(avih,
+		uint32(1000000/aw.fps), // microseconds per frame
+		bufSize*uint32(aw.fps), // max bytes per second
+		0,                      // padding granularity
+		aviHasIndex,
+		frames,
+		0, // initial frames
+		1, // streams
+		bufSize,
+		width,
+		height,
+	)
+
+	strh := make([]byte, 56)
+	copy(strh, "vidsMJPG")
+	putUint32s(strh[8:],
+		0, // flags
+		0, // priority and language
+		0, // initial frames
+		1, // scale
+		uint32(aw.fps),
+		0, // start
+		frames,
+		bufSize,
+		0xffffffff, // quality (default)
+		0,          // sample size
+	)
+	binary.LittleEndian.PutUint16(strh[52:], uint16(width))
+	binary.LittleEndian.PutUint16(strh[54:], uint16(height))
+
+	strf := make([]byte, 40) // BITMAPINFOHEADER
+	putUint32s(strf, 40, width, height)
+	binary.LittleEndian.PutUint16(strf[12:], 1)  // planes
+	binary.LittleEndian.PutUint16(strf[14:], 24) // bit count
+	copy(strf[16:], "MJPG")
+	binary.LittleEndian.PutUint32(strf[20:], width*height*3)
+
+	strl := list("strl", chunk("strh", strh), chunk("strf", strf))
+	hdrl := list("hdrl", chunk("avih", avih), strl)
+	return append([]byte("RIFF\x00\x00\x00\x00AVI "), hdrl...)
+}
+
+func putUint32s(b []byte, vals ...uint32) {
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(b[i*4:], v)
+	}
+}
+
+func chunk(id string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data))
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	return append(b, data...)
+}
+
+func list(typ string, chunks ...[]byte) []byte {
+	data := []byte(typ)
+	for _, c := range chunks {
+		data = append(data, c...)
+	}
+	return chunk("LIST", data)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+func TestWriteAVI(t *testing.T) {
+	r := testRecording(t, 5)
+	w := new(writeSeeker)
+	require.NoError(t, WriteAVI(w, r, AVIOptions{Scale: 4, Overlay: true}))
+	data := w.buf
+
+	assert.Equal(t, "RIFF", string(data[:4]))
+	assert.Equal(t, uint32(len(data)-8), binary.LittleEndian.Uint32(data[4:]))
+	assert.Equal(t, "AVI ", string(data[8:12]))
+
+	// Main AVI header.
+	avih := bytes.Index(data, []byte("avih"))
+	require.True(t, avih > 0)
+	header := data[avih+8:]
+	assert.Equal(t, uint32(1000000/9), binary.LittleEndian.Uint32(header[0:]))
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(header[16:]))
+	assert.Equal(t, uint32(16), binary.LittleEndian.Uint32(header[32:]))
+	assert.Equal(t, uint32(12), binary.LittleEndian.Uint32(header[36:]))
+
+	// Index.
+	idx1 := bytes.LastIndex(data, []byte("idx1"))
+	require.True(t, idx1 > 0)
+	assert.Equal(t, uint32(5*16), binary.LittleEndian.Uint32(data[idx1+4:]))
+	assert.Equal(t, len(data), idx1+8+5*16)
+
+	// The index points at the frames, relative to the movi list.
+	movi := bytes.Index(data, []byte("movi"))
+	assert.Equal(t, "LIST", string(data[movi-8:movi-4]))
+	assert.Equal(t, uint32(idx1-movi), binary.LittleEndian.Uint32(data[movi-4:]))
+	entry := data[idx1+8:]
+	assert.Equal(t, "00dc", string(entry[:4]))
+	offset := int(binary.LittleEndian.Uint32(entry[8:]))
+	size := int(binary.LittleEndian.Uint32(entry[12:]))
+	frameStart := movi + offset + 8
+	img, err := jpeg.Decode(bytes.NewReader(data[frameStart : frameStart+size]))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 12), img.Bounds())
+}
+
+func TestDrawText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	DrawText(img, "1", 1)
+	// A black box with "1" drawn in it, starting 1 pixel in.
+	assert.Equal(t, color.RGBA{0, 0, 0, 0xff}, img.At(0, 9))
+	assert.Equal(t, color.RGBA{0xff, 0xff, 0xff, 0xff}, img.At(2, 4))
+	assert.Equal(t, color.RGBA{0, 0, 0, 0xff}, img.At(1, 4))
+	assert.Equal(t, color.RGBA{}, img.At(10, 0))
+
+	status := cptvframe.Telemetry{TimeOn: 12345 * time.Millisecond, TempC: 21.56}
+	assert.Equal(t, "12.3s 21.6C", TelemetryText(&status))
+}
+
+func TestUpscale(t *testing.T) {
+	img := Render(testFrame(), AutoRange{}, Greyscale)
+	big := Upscale(img, 3)
+	assert.Equal(t, image.Rect(0, 0, 12, 9), big.Bounds())
+	assert.Equal(t, img.At(3, 2), big.At(11, 8))
+	assert.Equal(t, img.At(1, 0), big.At(5, 2))
+}
+
+// writeSeeker is an in-memory io.WriteSeeker.
+type writeSeeker struct {
+	buf []byte
+	pos int
+}
+
+func (w *writeSeeker) Write(p []byte) (int, error) {
+	if end := w.pos + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	copy(w.buf[w.pos:], p)
+	w.pos += len(p)
+	return len(p), nil
+}
+
+func (w *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		w.pos = int(offset)
+	case io.SeekCurrent:
+		w.pos += int(offset)
+	case io.SeekEnd:
+		w.pos = len(w.buf) + int(offset)
+	}
+	if w.pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	return int64(w.pos), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+)
+
+// ColourMap maps normalised thermal values (0-255) to colours.
+type ColourMap struct {
+	Name    string
+	Palette color.Palette
+}
+
+// Standard colour maps.
+var (
+	Greyscale = newColourMap("greyscale", 0x000000, 0xffffff)
+	BlackHot  = newColourMap("blackhot", 0xffffff, 0x000000)
+	Ironbow   = newColourMap("ironbow",
+		0x000000, 0x1c0a6e, 0x7a0e9a, 0xc2207a, 0xe8471f, 0xf98a0a, 0xfdd33a, 0xffffff)
+	Viridis = newColourMap("viridis",
+		0x440154, 0x472c7a, 0x3b518b, 0x2c718e, 0x21908d, 0x27ad81, 0x5cc863, 0xaadc32, 0xfde725)
+	Inferno = newColourMap("inferno",
+		0x000004, 0x1f0c48, 0x550f6d, 0x88226a, 0xba3655, 0xe35933, 0xf98c0a, 0xf9c932, 0xfcffa4)
+)
+
+var colourMaps = map[string]*ColourMap{
+	Greyscale.Name: Greyscale,
+	BlackHot.Name:  BlackHot,
+	Ironbow.Name:   Ironbow,
+	Viridis.Name:   Viridis,
+	Inferno.Name:   Inferno,
+}
+
+// LookupColourMap returns the standard colour map with the given
+// name.
+func LookupColourMap(name string) (*ColourMap, error) {
+	if m, ok := colourMaps[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown colour map %q (known colour maps: %v)", name, ColourMapNames())
+}
+
+// ColourMapNames returns the names of the standard colour maps.
+func ColourMapNames() []string {
+	names := make([]string, 0, len(colourMaps))
+	for name := range colourMaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newColourMap returns a 256 colour map which interpolates linearly
+// between evenly spaced RGB colours.
+func newColourMap(name string, stops ...uint32) *ColourMap {
+	palette := make(color.Palette, 256)
+	segments := len(stops) - 1
+	for i := range palette {
+		pos := float64(i) * float64(segments) / 255
+		seg := int(pos)
+		if seg == segments {
+			seg--
+		}
+		frac := pos - float64(seg)
+		from, to := rgb(stops[seg]), rgb(stops[seg+1])
+		palette[i] = color.RGBA{
+			R: lerp(from.R, to.R, frac),
+			G: lerp(from.G, to.G, frac),
+			B: lerp(from.B, to.B, frac),
+			A: 0xff,
+		}
+	}
+	return &ColourMap{Name: name, Palette: palette}
+}
+
+func rgb(c uint32) color.RGBA {
+	return color.RGBA{R: uint8(c >> 16), G: uint8(c >> 8), B: uint8(c), A: 0xff}
+}
+
+func lerp(a, b uint8, frac float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*frac + 0.5)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// Normaliser chooses the range of thermal values which are spread
+// over the colours of a ColourMap. Values outside the range are
+// clamped.
+type Normaliser interface {
+	Range(frame *cptvframe.Frame) (lo, hi uint16)
+}
+
+// AutoRange uses the minimum and maximum values of each frame.
+type AutoRange struct{}
+
+// Range implements Normaliser.
+func (AutoRange) Range(frame *cptvframe.Frame) (uint16, uint16) {
+	lo, hi := uint16(0xffff), uint16(0)
+	for _, row := range frame.Pix {
+		for _, v := range row {
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+	}
+	if lo > hi {
+		return 0, 0
+	}
+	return lo, hi
+}
+
+// FixedRange uses the same range for every frame, so that colours
+// are consistent between frames.
+type FixedRange struct {
+	Lo, Hi uint16
+}
+
+// Range implements Normaliser.
+func (r FixedRange) Range(*cptvframe.Frame) (uint16, uint16) {
+	return r.Lo, r.Hi
+}
+
+// PercentileRange uses the Low and High percentiles (0-100) of each
+// frame's values, so that a few very hot or cold pixels don't reduce
+// the contrast of the rest of the frame.
+type PercentileRange struct {
+	Low, High float64
+}
+
+// Range implements Normaliser.
+func (r PercentileRange) Range(frame *cptvframe.Frame) (uint16, uint16) {
+	var vals []uint16
+	for _, row := range frame.Pix {
+		vals = append(vals, row...)
+	}
+	if len(vals) == 0 {
+		return 0, 0
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	return vals[percentileIndex(r.Low, len(vals))], vals[percentileIndex(r.High, len(vals))]
+}
+
+func percentileIndex(p float64, n int) int {
+	i := int(p/100*float64(n-1) + 0.5)
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// ParseNormaliser parses a normaliser description: "auto",
+// "<lo>:<hi>" for a FixedRange or "percentile:<low>:<high>" for a
+// PercentileRange.
+func ParseNormaliser(s string) (Normaliser, error) {
+	if s == "auto" {
+		return AutoRange{}, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) == 3 && parts[0] == "percentile" {
+		low, lowErr := strconv.ParseFloat(parts[1], 64)
+		high, highErr := strconv.ParseFloat(parts[2], 64)
+		if lowErr != nil || highErr != nil || !(low >= 0 && high <= 100 && low <= high) {
+			return nil, fmt.Errorf("invalid percentiles %q", s)
+		}
+		return PercentileRange{Low: low, High: high}, nil
+	}
+	if len(parts) == 2 {
+		lo, loErr := strconv.ParseUint(parts[0], 10, 16)
+		hi, hiErr := strconv.ParseUint(parts[1], 10, 16)
+		if loErr == nil && hiErr == nil && lo <= hi {
+			return FixedRange{Lo: uint16(lo), Hi: uint16(hi)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid range %q", s)
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// glyphs is a 3x5 pixel font covering the characters needed for
+// telemetry overlays. Each row is 3 bits, most significant bit on the
+// left.
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'.': {0, 0, 0, 0, 2},
+	'-': {0, 0, 7, 0, 0},
+	's': {0, 3, 4, 1, 6},
+	'C': {7, 4, 4, 4, 7},
+	' ': {0, 0, 0, 0, 0},
+}
+
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+)
+
+// TelemetryText returns the text shown by DrawTelemetry.
+func TelemetryText(status *cptvframe.Telemetry) string {
+	return fmt.Sprintf("%.1fs %.1fC", status.TimeOn.Seconds(), status.TempC)
+}
+
+// DrawTelemetry draws a frame's time on and temperature in the
+// bottom left corner of img, with each pixel of the font scaled to
+// size x size pixels.
+func DrawTelemetry(img draw.Image, status *cptvframe.Telemetry, size int) {
+	DrawText(img, TelemetryText(status), size)
+}
+
+// DrawText draws white text on a black background in the bottom left
+// corner of img. Only digits, spaces and the characters ".-sC" are
+// supported; other characters are drawn as spaces.
+func DrawText(img draw.Image, text string, size int) {
+	if size < 1 {
+		size = 1
+	}
+	b := img.Bounds()
+	width := (len(text)*(glyphWidth+1) + 1) * size
+	height := (glyphHeight + 2) * size
+	origin := image.Pt(b.Min.X, b.Max.Y-height)
+	draw.Draw(img, image.Rect(origin.X, origin.Y, origin.X+width, b.Max.Y), image.Black, image.Point{}, draw.Src)
+
+	for i, r := range text {
+		glyph := glyphs[r]
+		left := origin.X + (i*(glyphWidth+1)+1)*size
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<uint(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				x := left + col*size
+				y := origin.Y + (row+1)*size
+				draw.Draw(img, image.Rect(x, y, x+size, y+size), image.White, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// Upscale returns img enlarged by factor using nearest neighbour
+// scaling, so that individual thermal pixels stay sharp.
+func Upscale(img image.Image, factor int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			for dy := 0; dy < factor; dy++ {
+				i := out.PixOffset((x-b.Min.X)*factor, (y-b.Min.Y)*factor+dy)
+				for dx := 0; dx < factor; dx++ {
+					out.Pix[i] = c.R
+					out.Pix[i+1] = c.G
+					out.Pix[i+2] = c.B
+					out.Pix[i+3] = c.A
+					i += 4
+				}
+			}
+		}
+	}
+	return out
+}
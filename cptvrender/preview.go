@@ -0,0 +1,257 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// PreviewFormat identifies the format of an animated preview.
+type PreviewFormat int
+
+// Preview formats.
+const (
+	GIF PreviewFormat = iota
+	APNG
+)
+
+// PreviewOptions controls how a preview is generated. The zero value
+// gives a GIF of every frame using the Ironbow colour map.
+type PreviewOptions struct {
+	Format    PreviewFormat
+	ColourMap *ColourMap
+
+	// Normaliser sets the range of values coloured. If nil, the range
+	// of the whole recording is used so that colours are consistent
+	// throughout the preview (see ClipRange).
+	Normaliser Normaliser
+
+	// Every subsamples the frames, using only every nth frame. The
+	// delay between frames is increased to match so the preview plays
+	// at the same speed as the recording.
+	Every int
+}
+
+// WritePreview writes an animated preview of the remaining frames of
+// a recording to w. The background frame isn't included.
+//
+// Unless a Normaliser is given, the frames are read twice so the
+// Reader must be able to seek (see cptv.Reader.SeekFrame).
+func WritePreview(w io.Writer, r *cptv.Reader, opts PreviewOptions) error {
+	cmap := opts.ColourMap
+	if cmap == nil {
+		cmap = Ironbow
+	}
+	every := opts.Every
+	if every < 1 {
+		every = 1
+	}
+	n := opts.Normaliser
+	if n == nil {
+		clip, err := ClipRange(r)
+		if err != nil {
+			return err
+		}
+		n = clip
+	}
+
+	var images []*image.Paletted
+	frame := r.EmptyFrame()
+	for i := 0; ; {
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if frame.Status.BackgroundFrame {
+			continue
+		}
+		if i%every == 0 {
+			images = append(images, Render(frame, n, cmap))
+		}
+		i++
+	}
+	if len(images) == 0 {
+		return errors.New("no frames to preview")
+	}
+
+	fps := r.FPS()
+	if opts.Format == APNG {
+		return writeAPNG(w, images, uint16(every), uint16(fps))
+	}
+	anim := &gif.GIF{
+		Image: images,
+		Delay: make([]int, len(images)),
+	}
+	// GIF delays are in 100ths of a second. Spread the rounding
+	// errors so the preview doesn't drift.
+	for i := range anim.Delay {
+		anim.Delay[i] = centis(i+1, every, fps) - centis(i, every, fps)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+func centis(frames, every, fps int) int {
+	return (frames*every*200 + fps) / (2 * fps)
+}
+
+// ClipRange returns the range of values in the remaining frames of a
+// recording, for colouring the frames consistently. The position of
+// the Reader is restored afterwards so it must be able to seek (see
+// cptv.Reader.SeekFrame).
+func ClipRange(r *cptv.Reader) (FixedRange, error) {
+	start := r.Position()
+	rng := FixedRange{Lo: 0xffff}
+	frame := r.EmptyFrame()
+	for {
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return FixedRange{}, err
+		}
+		if frame.Status.BackgroundFrame {
+			continue
+		}
+		lo, hi := AutoRange{}.Range(frame)
+		if lo < rng.Lo {
+			rng.Lo = lo
+		}
+		if hi > rng.Hi {
+			rng.Hi = hi
+		}
+	}
+	if rng.Lo > rng.Hi {
+		rng = FixedRange{}
+	}
+	return rng, r.SeekFrame(start)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// writeAPNG writes images as an animated PNG, each shown for
+// delayNum/delayDen seconds. The images are encoded using image/png
+// and their chunks rearranged.
+func writeAPNG(w io.Writer, images []*image.Paletted, delayNum, delayDen uint16) error {
+	bw := &chunkWriter{w: w}
+	bw.write(pngSignature)
+	seq := uint32(0)
+	for i, img := range images {
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, img); err != nil {
+			return err
+		}
+		chunks, err := readChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// Copy the header chunks (IHDR, PLTE, tRNS) and add the
+			// animation control chunk.
+			for _, c := range chunks {
+				if c.typ == "IDAT" {
+					break
+				}
+				bw.chunk(c.typ, c.data)
+				if c.typ == "IHDR" {
+					actl := make([]byte, 8)
+					binary.BigEndian.PutUint32(actl[0:], uint32(len(images)))
+					binary.BigEndian.PutUint32(actl[4:], 0) // loop forever
+					bw.chunk("acTL", actl)
+				}
+			}
+		}
+
+		bounds := img.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+		// x and y offsets are 0.
+		binary.BigEndian.PutUint16(fctl[20:], delayNum)
+		binary.BigEndian.PutUint16(fctl[22:], delayDen)
+		// dispose and blend ops are 0 (none, source).
+		bw.chunk("fcTL", fctl)
+		seq++
+
+		for _, c := range chunks {
+			if c.typ != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				bw.chunk("IDAT", c.data)
+				continue
+			}
+			fdat := make([]byte, 4, 4+len(c.data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			bw.chunk("fdAT", append(fdat, c.data...))
+			seq++
+		}
+	}
+	bw.chunk("IEND", nil)
+	return bw.err
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// readChunks splits an encoded PNG into its chunks.
+func readChunks(b []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return nil, errors.New("invalid PNG signature")
+	}
+	b = b[len(pngSignature):]
+	var chunks []pngChunk
+	for len(b) >= 12 {
+		n := int(binary.BigEndian.Uint32(b))
+		if len(b) < 12+n {
+			break
+		}
+		chunks = append(chunks, pngChunk{typ: string(b[4:8]), data: b[8 : 8+n]})
+		b = b[12+n:]
+	}
+	if len(b) != 0 {
+		return nil, errors.New("invalid PNG chunk")
+	}
+	return chunks, nil
+}
+
+// chunkWriter writes PNG chunks, recording the first error.
+type chunkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *chunkWriter) write(b []byte) {
+	if cw.err == nil {
+		_, cw.err = cw.w.Write(b)
+	}
+}
+
+func (cw *chunkWriter) chunk(typ string, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+	cw.write(header)
+	cw.write(data)
+	cw.write(footer)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+func TestPreviewGIF(t *testing.T) {
+	r := testRecording(t, 10)
+	buf := new(bytes.Buffer)
+	require.NoError(t, WritePreview(buf, r, PreviewOptions{Every: 3}))
+
+	anim, err := gif.DecodeAll(buf)
+	require.NoError(t, err)
+	// Frames 0, 3, 6 and 9 at 9 FPS.
+	require.Len(t, anim.Image, 4)
+	assert.Equal(t, []int{33, 34, 33, 33}, anim.Delay)
+
+	// The range of the whole recording is used: the first frame is
+	// the coldest and the last the hottest.
+	assert.Equal(t, uint8(0), anim.Image[0].ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(255), anim.Image[3].ColorIndexAt(3, 2))
+}
+
+func TestPreviewAPNG(t *testing.T) {
+	r := testRecording(t, 5)
+	buf := new(bytes.Buffer)
+	require.NoError(t, WritePreview(buf, r, PreviewOptions{Format: APNG, ColourMap: Viridis}))
+
+	chunks, err := readChunks(buf.Bytes())
+	require.NoError(t, err)
+	var types []string
+	for _, c := range chunks {
+		types = append(types, c.typ)
+	}
+	assert.Equal(t, []string{
+		"IHDR", "acTL", "PLTE", "fcTL", "IDAT",
+		"fcTL", "fdAT", "fcTL", "fdAT", "fcTL", "fdAT", "fcTL", "fdAT",
+		"IEND",
+	}, types)
+	assert.Equal(t, uint32(5), binary.BigEndian.Uint32(chunks[1].data))
+
+	// Viewers without APNG support show the first frame.
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, Viridis.Palette[0], img.At(0, 0))
+}
+
+func TestClipRange(t *testing.T) {
+	r := testRecording(t, 5)
+	rng, err := ClipRange(r)
+	require.NoError(t, err)
+	assert.Equal(t, FixedRange{Lo: 1000, Hi: 2500}, rng)
+	assert.Equal(t, 1, r.Position())
+}
+
+// testRecording returns a Reader for a recording with a background
+// frame and n frames, each 100 warmer than the last.
+func testRecording(t *testing.T, n int) *cptv.Reader {
+	buf := new(bytes.Buffer)
+	w := cptv.NewWriter(buf, testCamera{})
+	background := cptvframe.NewFrame(testCamera{})
+	require.NoError(t, w.WriteHeader(cptv.Header{FPS: 9, BackgroundFrame: background}))
+	for i := 0; i < n; i++ {
+		frame := testFrame()
+		for _, row := range frame.Pix {
+			for x := range row {
+				row[x] += uint16(100 * i)
+			}
+		}
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := cptv.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	// Skip the background frame.
+	require.NoError(t, r.ReadFrame(r.EmptyFrame()))
+	return r
+}
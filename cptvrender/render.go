@@ -0,0 +1,67 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+// Package cptvrender converts thermal frames to images.
+package cptvrender
+
+import (
+	"image"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// Gray16 returns the raw thermal values of a frame as a 16-bit
+// greyscale image. No information is lost but most viewers will show
+// the image as almost black as thermal values only use a small part
+// of the range.
+func Gray16(frame *cptvframe.Frame) *image.Gray16 {
+	img := image.NewGray16(frameBounds(frame))
+	for y, row := range frame.Pix {
+		for x, v := range row {
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(v >> 8)
+			img.Pix[i+1] = uint8(v)
+		}
+	}
+	return img
+}
+
+// Render returns a frame as an 8-bit image coloured using cmap. The
+// range of values used is chosen by n.
+func Render(frame *cptvframe.Frame, n Normaliser, cmap *ColourMap) *image.Paletted {
+	img := image.NewPaletted(frameBounds(frame), cmap.Palette)
+	RenderInto(img, frame, n)
+	return img
+}
+
+// RenderInto renders a frame into an existing image, which must be
+// the same size as the frame, using the image's palette.
+func RenderInto(img *image.Paletted, frame *cptvframe.Frame, n Normaliser) {
+	lo, hi := n.Range(frame)
+	levels := len(img.Palette) - 1
+	for y, row := range frame.Pix {
+		out := img.Pix[y*img.Stride : y*img.Stride+len(row)]
+		for x, v := range row {
+			out[x] = uint8(scale(v, lo, hi, levels))
+		}
+	}
+}
+
+// scale maps v from the range lo-hi to 0-levels.
+func scale(v, lo, hi uint16, levels int) int {
+	if v <= lo || hi <= lo {
+		return 0
+	}
+	if v >= hi {
+		return levels
+	}
+	return int(v-lo) * levels / int(hi-lo)
+}
+
+func frameBounds(frame *cptvframe.Frame) image.Rectangle {
+	if len(frame.Pix) == 0 {
+		return image.Rect(0, 0, 0, 0)
+	}
+	return image.Rect(0, 0, len(frame.Pix[0]), len(frame.Pix))
+}
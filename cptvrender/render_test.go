@@ -0,0 +1,100 @@
+// Copyright 2020 The Cacophony Project. All rights reserved.
+// Use of this source code is governed by the Apache License Version 2.0;
+// see the LICENSE file for further details.
+
+package cptvrender
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+type testCamera struct{}
+
+func (testCamera) ResX() int { return 4 }
+func (testCamera) ResY() int { return 3 }
+func (testCamera) FPS() int  { return 9 }
+
+func testFrame() *cptvframe.Frame {
+	frame := cptvframe.NewFrame(testCamera{})
+	for y, row := range frame.Pix {
+		for x := range row {
+			row[x] = uint16(1000 + 100*(y*4+x))
+		}
+	}
+	return frame
+}
+
+func TestGray16(t *testing.T) {
+	frame := testFrame()
+	img := Gray16(frame)
+	assert.Equal(t, 4, img.Bounds().Dx())
+	assert.Equal(t, 3, img.Bounds().Dy())
+	assert.Equal(t, color.Gray16{Y: 1000}, img.Gray16At(0, 0))
+	assert.Equal(t, color.Gray16{Y: 2100}, img.Gray16At(3, 2))
+}
+
+func TestRender(t *testing.T) {
+	frame := testFrame()
+	img := Render(frame, AutoRange{}, Greyscale)
+	assert.Equal(t, uint8(0), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(255), img.ColorIndexAt(3, 2))
+	assert.Equal(t, color.RGBA{0xff, 0xff, 0xff, 0xff}, img.At(3, 2))
+
+	img = Render(frame, FixedRange{Lo: 1500, Hi: 1600}, Greyscale)
+	assert.Equal(t, uint8(0), img.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(1, 1)) // 1500
+	assert.Equal(t, uint8(255), img.ColorIndexAt(2, 1))
+}
+
+func TestNormalisers(t *testing.T) {
+	frame := testFrame()
+	lo, hi := AutoRange{}.Range(frame)
+	assert.Equal(t, uint16(1000), lo)
+	assert.Equal(t, uint16(2100), hi)
+
+	frame.Pix[0][0] = 0
+	frame.Pix[2][3] = 60000
+	lo, hi = PercentileRange{Low: 10, High: 90}.Range(frame)
+	assert.Equal(t, uint16(1100), lo)
+	assert.Equal(t, uint16(2000), hi)
+}
+
+func TestParseNormaliser(t *testing.T) {
+	n, err := ParseNormaliser("auto")
+	require.NoError(t, err)
+	assert.Equal(t, AutoRange{}, n)
+	n, err = ParseNormaliser("2000:4000")
+	require.NoError(t, err)
+	assert.Equal(t, FixedRange{Lo: 2000, Hi: 4000}, n)
+	n, err = ParseNormaliser("percentile:1:99.5")
+	require.NoError(t, err)
+	assert.Equal(t, PercentileRange{Low: 1, High: 99.5}, n)
+
+	for _, s := range []string{
+		"", "4000:2000", "percentile:50:150", "hot", "2000:4000xyz", "2000:4000:6000",
+		"-1:4000", "2000:70000", "percentile:1:99.5%", "percentile:NaN:50",
+	} {
+		_, err := ParseNormaliser(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestColourMaps(t *testing.T) {
+	for _, name := range ColourMapNames() {
+		cmap, err := LookupColourMap(name)
+		require.NoError(t, err)
+		assert.Len(t, cmap.Palette, 256, name)
+	}
+	assert.Equal(t, color.RGBA{0, 0, 0, 0xff}, Ironbow.Palette[0])
+	assert.Equal(t, color.RGBA{0xff, 0xff, 0xff, 0xff}, Ironbow.Palette[255])
+	assert.Equal(t, color.RGBA{0x44, 0x01, 0x54, 0xff}, Viridis.Palette[0])
+	assert.Equal(t, color.RGBA{0xfd, 0xe7, 0x25, 0xff}, Viridis.Palette[255])
+	_, err := LookupColourMap("rainbow")
+	assert.Error(t, err)
+}
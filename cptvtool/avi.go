@@ -0,0 +1,60 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvrender"
+)
+
+func runAVI(args []string) error {
+	flags := newFlagSet("avi")
+	render := addRenderFlags(flags, "clip")
+	scale := flags.Int("scale", 4, "enlarge the video by this factor")
+	overlay := flags.Bool("overlay", true, "show each frame's time on and temperature")
+	quality := flags.Int("quality", 90, "JPEG quality (1-100)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return commandUsage("avi")
+	}
+	inName, outName := flags.Arg(0), flags.Arg(1)
+	n, cmap, err := render.parse()
+	if err != nil {
+		return err
+	}
+
+	return writeAVIFile(inName, outName, cptvrender.AVIOptions{
+		ColourMap:  cmap,
+		Normaliser: n,
+		Scale:      *scale,
+		Overlay:    *overlay,
+		Quality:    *quality,
+	})
+}
+
+func writeAVIFile(inName, outName string, opts cptvrender.AVIOptions) error {
+	fr, err := cptv.NewFileReader(inName)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	return writeFileOutput(outName, func(f *os.File) error {
+		return cptvrender.WriteAVI(f, fr.Reader, opts)
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// recording is a CPTV file given to a command.
+type recording struct {
+	name    string // path to the file
+	relName string // name relative to the directory given, for naming output files
+}
+
+// outputName returns the name of an output file for the recording in
+// outDir, with the .cptv extension replaced by ext. The directory
+// layout of the inputs is kept.
+func (r recording) outputName(outDir, ext string) string {
+	base := strings.TrimSuffix(r.relName, filepath.Ext(r.relName))
+	return filepath.Join(outDir, base+ext)
+}
+
+// findRecordings returns the recordings named by inputs. Each input
+// may be a file, a directory (see walkRecordings) or a glob pattern,
+// for shells which don't expand patterns.
+func findRecordings(inputs []string) ([]recording, error) {
+	var recs []recording
+	add := func(name, relName string) error {
+		recs = append(recs, recording{name, relName})
+		return nil
+	}
+	for _, input := range inputs {
+		names := []string{input}
+		if _, err := os.Stat(input); err != nil && strings.ContainsAny(input, "*?[") {
+			if names, err = filepath.Glob(input); err != nil {
+				return nil, fmt.Errorf("%s: %v", input, err)
+			}
+			if len(names) == 0 {
+				return nil, fmt.Errorf("no files match %s", input)
+			}
+		}
+		for _, name := range names {
+			if err := walkRecordings(name, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(recs) == 0 {
+		return nil, errors.New("no recordings found")
+	}
+	return recs, nil
+}
+
+// addJobsFlag adds the flag setting how many recordings are processed
+// at once.
+func addJobsFlag(flags *flag.FlagSet) *int {
+	return flags.Int("j", runtime.NumCPU(), "number of recordings to process at once")
+}
+
+// forEachRecording calls fn for each recording, running up to jobs
+// calls at once. The output fn writes to out is shown in the order of
+// the recordings so that the output for different recordings isn't
+// mixed up. Errors are shown with the recording's name and an error
+// is returned if fn failed for any recording.
+func forEachRecording(recs []recording, jobs int, fn func(rec recording, out io.Writer) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	type result struct {
+		out  bytes.Buffer
+		err  error
+		done chan struct{}
+	}
+	results := make([]*result, len(recs))
+	for i := range results {
+		results[i] = &result{done: make(chan struct{})}
+	}
+	next := make(chan int)
+	go func() {
+		for i := range recs {
+			next <- i
+		}
+		close(next)
+	}()
+	for j := 0; j < jobs; j++ {
+		go func() {
+			for i := range next {
+				res := results[i]
+				res.err = fn(recs[i], &res.out)
+				close(res.done)
+			}
+		}()
+	}
+
+	failed := 0
+	for i, res := range results {
+		<-res.done
+		os.Stdout.Write(res.out.Bytes())
+		if res.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", recs[i].name, res.err)
+			failed++
+		}
+		results[i] = nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed for %d of %d recordings", failed, len(recs))
+	}
+	return nil
+}
+
+// walkRecordings calls fn for each CPTV recording named by
+// input. Directories are searched recursively for files with a .cptv
+// extension. fn is passed the name of each recording relative to the
+// directory given, or just the file's base name if input is a file.
+func walkRecordings(input string, fn func(name, relName string) error) error {
+	info, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(input, filepath.Base(input))
+	}
+	return filepath.Walk(input, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(name) != ".cptv" {
+			return nil
+		}
+		relName, err := filepath.Rel(input, name)
+		if err != nil {
+			return err
+		}
+		return fn(name, relName)
+	})
+}
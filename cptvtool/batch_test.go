@@ -0,0 +1,163 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRecordings(t *testing.T) {
+	dir := makeRecordingDir(t, "a.cptv", "notes.txt", "sub/b.cptv", "sub/deeper/c.cptv")
+	defer os.RemoveAll(dir)
+
+	recs, err := findRecordings([]string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, []recording{
+		{filepath.Join(dir, "a.cptv"), "a.cptv"},
+		{filepath.Join(dir, "sub", "b.cptv"), filepath.Join("sub", "b.cptv")},
+		{filepath.Join(dir, "sub", "deeper", "c.cptv"), filepath.Join("sub", "deeper", "c.cptv")},
+	}, recs)
+	assert.Equal(t,
+		filepath.Join("out", "sub", "b.mp4"),
+		recs[1].outputName("out", ".mp4"))
+
+	// A file is named by its base name, whatever its extension.
+	recs, err = findRecordings([]string{filepath.Join(dir, "notes.txt")})
+	require.NoError(t, err)
+	assert.Equal(t, []recording{{filepath.Join(dir, "notes.txt"), "notes.txt"}}, recs)
+}
+
+func TestFindRecordingsGlob(t *testing.T) {
+	dir := makeRecordingDir(t, "a.cptv", "b.cptv", "c.txt")
+	defer os.RemoveAll(dir)
+
+	recs, err := findRecordings([]string{filepath.Join(dir, "*.cptv")})
+	require.NoError(t, err)
+	var names []string
+	for _, rec := range recs {
+		names = append(names, rec.relName)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"a.cptv", "b.cptv"}, names)
+
+	_, err = findRecordings([]string{filepath.Join(dir, "*.avi")})
+	assert.Error(t, err)
+}
+
+func TestFindRecordingsErrors(t *testing.T) {
+	dir := makeRecordingDir(t, "c.txt")
+	defer os.RemoveAll(dir)
+
+	_, err := findRecordings([]string{dir})
+	assert.EqualError(t, err, "no recordings found")
+
+	_, err = findRecordings([]string{filepath.Join(dir, "missing.cptv")})
+	assert.True(t, os.IsNotExist(err), "got %v", err)
+}
+
+func TestForEachRecording(t *testing.T) {
+	var recs []recording
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("%02d.cptv", i)
+		recs = append(recs, recording{name, name})
+	}
+
+	for _, jobs := range []int{0, 1, 4, 100} {
+		stdout, stderr := captureOutput(t, func() {
+			err := forEachRecording(recs, jobs, func(rec recording, out io.Writer) error {
+				// Finish the later recordings first.
+				var i int
+				fmt.Sscanf(rec.name, "%d", &i)
+				time.Sleep(time.Duration(len(recs)-i) * time.Millisecond)
+				fmt.Fprintln(out, rec.name)
+				return nil
+			})
+			assert.NoError(t, err)
+		})
+		var want string
+		for _, rec := range recs {
+			want += rec.name + "\n"
+		}
+		assert.Equal(t, want, stdout, "jobs=%d", jobs)
+		assert.Empty(t, stderr)
+	}
+}
+
+func TestForEachRecordingErrors(t *testing.T) {
+	recs := []recording{{"a.cptv", "a.cptv"}, {"b.cptv", "b.cptv"}, {"c.cptv", "c.cptv"}}
+	var err error
+	stdout, stderr := captureOutput(t, func() {
+		err = forEachRecording(recs, 2, func(rec recording, out io.Writer) error {
+			fmt.Fprintln(out, rec.name)
+			if rec.name == "b.cptv" {
+				return errors.New("broken")
+			}
+			return nil
+		})
+	})
+	assert.EqualError(t, err, "failed for 1 of 3 recordings")
+	assert.Equal(t, "a.cptv\nb.cptv\nc.cptv\n", stdout)
+	assert.Equal(t, "b.cptv: broken\n", stderr)
+}
+
+// makeRecordingDir returns a new temporary directory containing empty
+// files with the given names.
+func makeRecordingDir(t *testing.T, names ...string) string {
+	dir, err := ioutil.TempDir("", "cptvtool")
+	require.NoError(t, err)
+	for _, name := range names {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, ioutil.WriteFile(name, nil, 0644))
+	}
+	return dir
+}
+
+// captureOutput calls fn and returns what it wrote to os.Stdout and
+// os.Stderr.
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	outFile, err := ioutil.TempFile("", "stdout")
+	require.NoError(t, err)
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	errFile, err := ioutil.TempFile("", "stderr")
+	require.NoError(t, err)
+	defer os.Remove(errFile.Name())
+	defer errFile.Close()
+
+	origOut, origErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	defer func() {
+		os.Stdout, os.Stderr = origOut, origErr
+	}()
+	fn()
+
+	outData, err := ioutil.ReadFile(outFile.Name())
+	require.NoError(t, err)
+	errData, err := ioutil.ReadFile(errFile.Name())
+	require.NoError(t, err)
+	return string(outData), string(errData)
+}
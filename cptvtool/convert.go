@@ -0,0 +1,120 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// compressionSchemes maps the names used on the command line to
+// compression schemes.
+var compressionSchemes = map[string]byte{
+	"raw":         cptv.CompressionRaw,
+	"delta":       cptv.CompressionDelta,
+	"rice":        cptv.CompressionRice,
+	"block-delta": cptv.CompressionBlockDelta,
+	"med":         cptv.CompressionMED,
+}
+
+// parseCompression returns the compression scheme with the given
+// name or number.
+func parseCompression(s string) (byte, error) {
+	if scheme, ok := compressionSchemes[s]; ok {
+		return scheme, nil
+	}
+	if scheme, err := strconv.ParseUint(s, 10, 8); err == nil {
+		return byte(scheme), nil
+	}
+	return 0, fmt.Errorf("unknown compression scheme %q", s)
+}
+
+func compressionNames() string {
+	names := make([]string, 0, len(compressionSchemes))
+	for name := range compressionSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func runConvert(args []string) error {
+	flags := newFlagSet("convert")
+	compression := flags.String("compression", "", "compression scheme: "+compressionNames()+" or a number (default: unchanged)")
+	keyframes := flags.Int("keyframe-interval", 0, "write a keyframe every n frames")
+	checksums := flags.Bool("checksums", false, "add a checksum to each frame (checksums are kept if the recording has them)")
+	footer := flags.Bool("footer", false, "add a footer, which readers older than the footer can't read (a footer is kept if the recording has one)")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return commandUsage("convert")
+	}
+
+	opts := []cptv.WriterOption{cptv.WithKeyframeInterval(*keyframes)}
+	if *compression != "" {
+		scheme, err := parseCompression(*compression)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, cptv.WithCompression(scheme))
+	}
+	if *checksums {
+		opts = append(opts, cptv.WithFrameChecksums())
+	}
+	if *footer {
+		opts = append(opts, cptv.WithFooter())
+	}
+
+	recs, err := findRecordings(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	outDir := flags.Arg(0)
+	return forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		outName := filepath.Join(outDir, rec.relName)
+		if err := convertFile(rec.name, outName, opts); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s -> %s\n", rec.name, outName)
+		return nil
+	})
+}
+
+func convertFile(inName, outName string, opts []cptv.WriterOption) error {
+	if sameFile(inName, outName) {
+		return errors.New("the output file must be different to the input file")
+	}
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(outName), 0755); err != nil {
+		return err
+	}
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptv.Convert(in, w, opts...)
+	})
+}
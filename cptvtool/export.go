@@ -0,0 +1,103 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvrender"
+)
+
+func runExport(args []string) error {
+	flags := newFlagSet("export")
+	format := flags.String("format", "gif", "output format: gif, apng, avi, npy, npz or png (a directory of frames per recording)")
+	render := addRenderFlags(flags, "clip")
+	every := flags.Int("every", 1, "gif and apng: only use every nth frame")
+	scale := flags.Int("scale", 4, "avi: enlarge the video by this factor")
+	overlay := flags.Bool("overlay", true, "avi: show each frame's time on and temperature")
+	quality := flags.Int("quality", 90, "avi: JPEG quality (1-100)")
+	raw := flags.Bool("raw", false, "png: write the raw values as 16-bit greyscale instead of colouring them")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return commandUsage("export")
+	}
+	n, cmap, err := render.parse()
+	if err != nil {
+		return err
+	}
+
+	// export writes the recording inName to outName.
+	var export func(inName, outName string) error
+	ext := "." + *format
+	switch *format {
+	case "gif", "apng":
+		opts := cptvrender.PreviewOptions{
+			ColourMap:  cmap,
+			Normaliser: n,
+			Every:      *every,
+		}
+		opts.Format, _ = parsePreviewFormat(*format)
+		if *format == "apng" {
+			ext = ".png"
+		}
+		export = func(inName, outName string) error {
+			return writePreviewFile(inName, outName, opts)
+		}
+	case "avi":
+		opts := cptvrender.AVIOptions{
+			ColourMap:  cmap,
+			Normaliser: n,
+			Scale:      *scale,
+			Overlay:    *overlay,
+			Quality:    *quality,
+		}
+		export = func(inName, outName string) error {
+			return writeAVIFile(inName, outName, opts)
+		}
+	case "npy", "npz":
+		export = writeNPYFile
+	case "png":
+		ext = ""
+		export = func(inName, outDir string) error {
+			_, err := writePNGFrames(inName, outDir, n, cmap, *raw)
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	recs, err := findRecordings(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	outDir := flags.Arg(0)
+	return forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		outName := rec.outputName(outDir, ext)
+		if err := os.MkdirAll(filepath.Dir(outName), 0755); err != nil {
+			return err
+		}
+		if err := export(rec.name, outName); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s -> %s\n", rec.name, outName)
+		return nil
+	})
+}
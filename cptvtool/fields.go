@@ -0,0 +1,130 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// fieldDecoder decodes a raw field for display.
+type fieldDecoder func(f cptv.Fields, code byte) (interface{}, error)
+
+// headerFieldTable describes the header fields defined by the CPTV
+// specification, in display order.
+var headerFieldTable = []struct {
+	code   byte
+	name   string
+	decode fieldDecoder
+}{
+	{cptv.Timestamp, "timestamp", timestampField},
+	{cptv.XResolution, "x_resolution", uint32Field},
+	{cptv.YResolution, "y_resolution", uint32Field},
+	{cptv.Compression, "compression", uint8Field},
+	{cptv.DeviceName, "device_name", stringField},
+	{cptv.DeviceID, "device_id", uint32Field},
+	{cptv.CameraSerial, "camera_serial", uint32Field},
+	{cptv.Model, "model", stringField},
+	{cptv.Brand, "brand", stringField},
+	{cptv.Firmware, "firmware", stringField},
+	{cptv.FPS, "fps", uint8Field},
+	{cptv.PreviewSecs, "preview_secs", uint8Field},
+	{cptv.MotionConfig, "motion_config", stringField},
+	{cptv.Latitude, "latitude", float32Field},
+	{cptv.Longitude, "longitude", float32Field},
+	{cptv.LocTimestamp, "loc_timestamp", timestampField},
+	{cptv.Altitude, "altitude", float32Field},
+	{cptv.Accuracy, "accuracy", float32Field},
+	{cptv.BackgroundFrame, "background_frame", uint8Field},
+}
+
+// namedField is a decoded field.
+type namedField struct {
+	name  string
+	value interface{}
+}
+
+// decodeHeader decodes all the fields of a header. Fields which
+// aren't defined by the CPTV specification, or which can't be
+// decoded, are shown as hex, named by their code.
+func decodeHeader(fields cptv.Fields) []namedField {
+	var out []namedField
+	known := make(map[byte]bool)
+	for _, hf := range headerFieldTable {
+		known[hf.code] = true
+		if _, ok := fields[hf.code]; !ok {
+			continue
+		}
+		v, err := hf.decode(fields, hf.code)
+		if err != nil {
+			v = hex.EncodeToString(fields[hf.code])
+		}
+		out = append(out, namedField{hf.name, v})
+	}
+	return append(out, unknownFields(fields, func(code byte) bool { return known[code] })...)
+}
+
+// unknownFields returns the fields whose codes known returns false
+// for, as hex, in order of their codes.
+func unknownFields(fields cptv.Fields, known func(code byte) bool) []namedField {
+	var codes []int
+	for code := range fields {
+		if !known(code) {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+	out := make([]namedField, len(codes))
+	for i, code := range codes {
+		out[i] = namedField{fieldName(byte(code)), hex.EncodeToString(fields[byte(code)])}
+	}
+	return out
+}
+
+// fieldName returns the name used for an unknown field.
+func fieldName(code byte) string {
+	if code >= ' ' && code <= '~' {
+		return fmt.Sprintf("field_%c", code)
+	}
+	return fmt.Sprintf("field_0x%02x", code)
+}
+
+func uint8Field(f cptv.Fields, code byte) (interface{}, error) {
+	return f.Uint8(code)
+}
+
+func uint32Field(f cptv.Fields, code byte) (interface{}, error) {
+	return f.Uint32(code)
+}
+
+func float32Field(f cptv.Fields, code byte) (interface{}, error) {
+	return f.Float32(code)
+}
+
+func stringField(f cptv.Fields, code byte) (interface{}, error) {
+	return f.String(code)
+}
+
+func timestampField(f cptv.Fields, code byte) (interface{}, error) {
+	ts, err := f.Timestamp(code)
+	if err != nil {
+		return nil, err
+	}
+	return ts.UTC().Format(time.RFC3339Nano), nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// frameColumn describes a column of the frames command's output.
+type frameColumn struct {
+	name  string
+	value func(n int, status *cptvframe.Telemetry, fields cptv.Fields) string
+}
+
+var frameColumns = []frameColumn{
+	{"frame", func(n int, _ *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.Itoa(n)
+	}},
+	{"background", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.FormatBool(s.BackgroundFrame)
+	}},
+	{"keyframe", func(_ int, _ *cptvframe.Telemetry, f cptv.Fields) string {
+		keyframe, _ := f.Uint8(cptv.Keyframe)
+		return strconv.FormatBool(keyframe != 0)
+	}},
+	{"time_on_ms", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.FormatInt(s.TimeOn.Milliseconds(), 10)
+	}},
+	{"last_ffc_time_ms", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.FormatInt(s.LastFFCTime.Milliseconds(), 10)
+	}},
+	{"temp_c", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.FormatFloat(s.TempC, 'f', -1, 32)
+	}},
+	{"last_ffc_temp_c", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.FormatFloat(s.LastFFCTempC, 'f', -1, 32)
+	}},
+	{"frame_count", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.Itoa(s.FrameCount)
+	}},
+	{"frame_mean", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return strconv.Itoa(int(s.FrameMean))
+	}},
+	{"ffc_state", func(_ int, s *cptvframe.Telemetry, _ cptv.Fields) string {
+		return s.FFCState
+	}},
+	{"bit_width", func(_ int, _ *cptvframe.Telemetry, f cptv.Fields) string {
+		bitWidth, _ := f.Uint8(cptv.BitWidth)
+		return strconv.Itoa(int(bitWidth))
+	}},
+	{"frame_size", func(_ int, _ *cptvframe.Telemetry, f cptv.Fields) string {
+		size, _ := f.Uint32(cptv.FrameSize)
+		return strconv.Itoa(int(size))
+	}},
+	{"crc", func(_ int, _ *cptvframe.Telemetry, f cptv.Fields) string {
+		if crc, err := f.Uint32(cptv.FrameCRC); err == nil {
+			return fmt.Sprintf("%08x", crc)
+		}
+		return ""
+	}},
+	{"extra", func(_ int, _ *cptvframe.Telemetry, f cptv.Fields) string {
+		return extraFieldsText(f)
+	}},
+}
+
+// extraFieldsText returns the frame fields which aren't understood by
+// the cptv package, and so don't have their own column, as name=hex
+// pairs.
+func extraFieldsText(f cptv.Fields) string {
+	var parts []string
+	for _, field := range unknownFields(f, cptv.IsFrameField) {
+		parts = append(parts, fmt.Sprintf("%s=%s", field.name, field.value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func runFrames(args []string) error {
+	flags := newFlagSet("frames")
+	csvOut := flags.Bool("csv", false, "write CSV with a header row")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return commandUsage("frames")
+	}
+	fr, err := cptv.NewFileReader(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	var write func(row []string) error
+	var flush func() error
+	if *csvOut {
+		cw := csv.NewWriter(os.Stdout)
+		write = cw.Write
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		write = func(row []string) error {
+			_, err := fmt.Fprintln(tw, strings.Join(row, "\t"))
+			return err
+		}
+		flush = tw.Flush
+	}
+
+	row := make([]string, len(frameColumns))
+	for i, col := range frameColumns {
+		row[i] = col.name
+	}
+	if err := write(row); err != nil {
+		return err
+	}
+	frame := fr.EmptyFrame()
+	for n := 0; ; n++ {
+		err := fr.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			flush()
+			return fmt.Errorf("frame %d: %v", n, err)
+		}
+		fields := fr.FrameFields()
+		for i, col := range frameColumns {
+			row[i] = col.value(n, &frame.Status, fields)
+		}
+		if err := write(row); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
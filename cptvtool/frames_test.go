@@ -0,0 +1,34 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtraFieldsText(t *testing.T) {
+	fields := cptv.Fields{
+		cptv.TimeOn:       {1, 0, 0, 0},
+		cptv.FrameCRC:     {1, 2, 3, 4},
+		cptv.Keyframe:     {1},
+		'z':               {0xab},
+		cptv.UserFieldMin: {0x01, 0x02},
+	}
+	assert.Equal(t, "field_z=ab field_0x80=0102", extraFieldsText(fields))
+	assert.Equal(t, "", extraFieldsText(cptv.Fields{cptv.TimeOn: {1, 0, 0, 0}}))
+}
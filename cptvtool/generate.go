@@ -0,0 +1,150 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// camera describes the recording made by the generate command.
+type camera struct {
+	cols, rows, fps int
+}
+
+func (c *camera) ResX() int { return c.cols }
+func (c *camera) ResY() int { return c.rows }
+func (c *camera) FPS() int  { return c.fps }
+
+func runGenerate(args []string) error {
+	flags := newFlagSet("generate")
+	frames := flags.Int("frames", 27, "number of frames")
+	cols := flags.Int("width", 160, "frame width")
+	rows := flags.Int("height", 120, "frame height")
+	fps := flags.Int("fps", 9, "frames per second")
+	compression := flags.String("compression", "delta", "compression scheme: "+compressionNames()+" or a number")
+	background := flags.Bool("background", false, "start with a background frame")
+	checksums := flags.Bool("checksums", false, "add a checksum to each frame")
+	footer := flags.Bool("footer", false, "add a footer, which readers older than the footer can't read")
+	timestamp := flags.String("timestamp", "", "recording time, RFC 3339 (default: now)")
+	deviceName := flags.String("device-name", "nz42", "device name")
+	deviceID := flags.Int("device-id", 90, "device ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return commandUsage("generate")
+	}
+	if *frames < 1 || *cols < 1 || *rows < 1 || *fps < 1 || *fps > 255 {
+		return errors.New("-frames, -width, -height and -fps must be positive (and -fps at most 255)")
+	}
+	scheme, err := parseCompression(*compression)
+	if err != nil {
+		return err
+	}
+	ts := time.Now()
+	if *timestamp != "" {
+		if ts, err = time.Parse(time.RFC3339, *timestamp); err != nil {
+			return err
+		}
+	}
+
+	cam := &camera{*cols, *rows, *fps}
+	opts := []cptv.WriterOption{cptv.WithCompression(scheme)}
+	if *checksums {
+		opts = append(opts, cptv.WithFrameChecksums())
+	}
+	if *footer {
+		opts = append(opts, cptv.WithFooter())
+	}
+	header := cptv.Header{
+		Timestamp:    ts,
+		DeviceName:   *deviceName,
+		DeviceID:     *deviceID,
+		FPS:          *fps,
+		PreviewSecs:  1,
+		MotionConfig: "generated by cptvtool",
+		Latitude:     -36.86667,
+		Longitude:    174.76667,
+		LocTimestamp: ts,
+		Altitude:     200,
+		Accuracy:     10,
+	}
+	if *background {
+		header.BackgroundFrame = generateFrame(cam, -1)
+	}
+	outName := flags.Arg(0)
+	err = writeOutput(outName, func(out io.Writer) error {
+		w := cptv.NewWriter(out, cam, opts...)
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		for i := 0; i < *frames; i++ {
+			if err := w.WriteFrame(generateFrame(cam, i)); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d frames to %s\n", *frames, outName)
+	return nil
+}
+
+// generateFrame returns frame i of a generated recording: a warm
+// square moving across a patterned background. The square is left
+// out of the background frame (i < 0).
+func generateFrame(cam *camera, i int) *cptvframe.Frame {
+	const (
+		minVal = 1024
+		maxVal = 8196
+		warm   = 2000
+	)
+	frame := cptvframe.NewFrame(cam)
+	for y, row := range frame.Pix {
+		for x := range row {
+			row[x] = uint16((y*x)%(maxVal-minVal) + minVal)
+		}
+	}
+	if i < 0 {
+		frame.Status.BackgroundFrame = true
+		return frame
+	}
+
+	size := cam.rows / 6
+	if size < 1 {
+		size = 1
+	}
+	x0 := i % cam.cols
+	y0 := (cam.rows - size) / 2
+	for y := y0; y < y0+size && y < cam.rows; y++ {
+		for x := x0; x < x0+size && x < cam.cols; x++ {
+			frame.Pix[y][x] += warm
+		}
+	}
+	frame.Status.TimeOn = time.Minute + time.Duration(i)*time.Second/time.Duration(cam.fps)
+	frame.Status.LastFFCTime = time.Minute / 2
+	frame.Status.TempC = 22.5
+	frame.Status.LastFFCTempC = 22
+	frame.Status.FrameCount = i
+	return frame
+}
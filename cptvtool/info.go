@@ -0,0 +1,121 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// recordingInfo is the output of the info command.
+type recordingInfo struct {
+	File            string                 `json:"file"`
+	Version         int                    `json:"version"`
+	Header          map[string]interface{} `json:"header"`
+	Frames          int                    `json:"frames"`
+	BackgroundFrame bool                   `json:"background_frame"`
+	FirstTimeOnMs   int64                  `json:"first_time_on_ms"`
+	LastTimeOnMs    int64                  `json:"last_time_on_ms"`
+	DurationMs      int64                  `json:"duration_ms"`
+
+	header []namedField
+}
+
+func runInfo(args []string) error {
+	flags := newFlagSet("info")
+	jsonOut := flags.Bool("json", false, "write JSON, one object per recording")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("info")
+	}
+	recs, err := findRecordings(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	return forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		info, err := readInfo(rec.name)
+		if err != nil {
+			return err
+		}
+		if *jsonOut {
+			return json.NewEncoder(out).Encode(info)
+		}
+		if rec != recs[0] {
+			fmt.Fprintln(out)
+		}
+		printInfo(out, info)
+		return nil
+	})
+}
+
+func readInfo(name string) (*recordingInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	summary, err := cptv.Probe(f)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cptv.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &recordingInfo{
+		File:            name,
+		Version:         r.Version(),
+		Header:          make(map[string]interface{}),
+		Frames:          summary.FrameCount,
+		BackgroundFrame: summary.BackgroundFrame,
+		FirstTimeOnMs:   summary.FirstTimeOn.Milliseconds(),
+		LastTimeOnMs:    summary.LastTimeOn.Milliseconds(),
+		DurationMs:      summary.Duration.Milliseconds(),
+		header:          decodeHeader(r.HeaderFields()),
+	}
+	for _, f := range info.header {
+		info.Header[f.name] = f.value
+	}
+	return info, nil
+}
+
+func printInfo(w io.Writer, info *recordingInfo) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file:\t%s\n", info.File)
+	fmt.Fprintf(tw, "version:\t%d\n", info.Version)
+	for _, f := range info.header {
+		// Multi-line values such as the motion config are quoted to
+		// keep to one line per field.
+		if s, ok := f.value.(string); ok && strings.ContainsAny(s, "\r\n") {
+			fmt.Fprintf(tw, "%s:\t%q\n", f.name, s)
+		} else {
+			fmt.Fprintf(tw, "%s:\t%v\n", f.name, f.value)
+		}
+	}
+	fmt.Fprintf(tw, "frames:\t%d\n", info.Frames)
+	fmt.Fprintf(tw, "duration:\t%.3fs\n", float64(info.DurationMs)/1000)
+	tw.Flush()
+}
@@ -15,27 +15,195 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/TheCacophonyProject/go-cptv"
 )
 
+// command is a cptvtool subcommand.
+type command struct {
+	args string // argument summary for usage messages
+	help string
+	run  func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	// Set up in init as the commands refer back to the table for
+	// their usage messages.
+	commands = map[string]command{
+		"avi": {
+			args: "[options] <file.cptv> <output.avi>",
+			help: "convert a recording to a Motion-JPEG AVI video",
+			run:  runAVI,
+		},
+		"concat": {
+			args: "<output.cptv> <file.cptv>...",
+			help: "join recordings of the same resolution",
+			run:  runConcat,
+		},
+		"convert": {
+			args: "[options] <output-dir> <recording>...",
+			help: "compress recordings again, e.g. with a different compression scheme",
+			run:  runConvert,
+		},
+		"export": {
+			args: "[options] <output-dir> <recording>...",
+			help: "export recordings as GIF or APNG previews, AVI videos, NumPy files or PNG frames",
+			run:  runExport,
+		},
+		"frames": {
+			args: "[options] <file.cptv>",
+			help: "list the fields and telemetry of each frame",
+			run:  runFrames,
+		},
+		"info": {
+			args: "[options] <recording>...",
+			help: "show all header fields and a summary of each recording",
+			run:  runInfo,
+		},
+		"generate": {
+			args: "[options] <output.cptv>",
+			help: "write a synthetic recording for testing",
+			run:  runGenerate,
+		},
+		"npy": {
+			args: "<file.cptv> <output.npy|output.npz>",
+			help: "export frames to a NumPy .npy file, or frames and telemetry to a .npz file",
+			run:  runNPY,
+		},
+		"png": {
+			args: "[options] <file.cptv> <output-dir>",
+			help: "write each frame of a recording to a numbered PNG file",
+			run:  runPNG,
+		},
+		"preview": {
+			args: "[options] <file.cptv> <output.gif|output.png>",
+			help: "write an animated GIF or APNG preview of a recording",
+			run:  runPreview,
+		},
+		"redact": {
+			args: "[options] <output-dir> <recording>...",
+			help: "remove or coarsen identifying header fields, e.g. before recordings are shared",
+			run:  runRedact,
+		},
+		"repair": {
+			args: "<damaged.cptv> <output.cptv>",
+			help: "recover the readable frames of a damaged recording",
+			run:  runRepair,
+		},
+		"set": {
+			args: "<file.cptv> <output.cptv> <field>=<value>...",
+			help: "change header fields without decoding the frames (output may be the input file)",
+			run:  runSet,
+		},
+		"stats": {
+			args: "[options] <recording>...",
+			help: "summarise the frames, pixel values and sizes of recordings",
+			run:  runStats,
+		},
+		"trim": {
+			args: "<file.cptv> <output.cptv> <start-frame> [<end-frame>]",
+			help: "keep the frames from start-frame up to (but not including) end-frame",
+			run:  runTrim,
+		},
+		"verify": {
+			args: "[options] <recording>...",
+			help: "check that recordings can be read and their frames aren't damaged",
+			run:  runVerify,
+		},
+		"verify-signature": {
+			args: "<public-key-file> <file.cptv>...",
+			help: "check that recordings were signed by the key's owner and haven't been modified",
+			run:  runVerifySignature,
+		},
+	}
+}
+
+// Exit codes
+const (
+	// exitFailed means the command failed, or failed for some of the
+	// recordings it was given.
+	exitFailed = 1
+	// exitUsage means the command line was invalid.
+	exitUsage = 2
+)
+
 func main() {
 	err := runMain()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if err == nil || err == flag.ErrHelp {
+		return
+	}
+	// The flag package shows its own errors along with the usage.
+	if !usageShown {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if _, ok := err.(usageErr); ok || usageShown {
+		os.Exit(exitUsage)
 	}
+	os.Exit(exitFailed)
 }
 
 func runMain() error {
+	if len(os.Args) < 2 {
+		return usageError()
+	}
+	if cmd, ok := commands[os.Args[1]]; ok {
+		return cmd.run(os.Args[2:])
+	}
 	if len(os.Args) != 2 {
-		return fmt.Errorf("usage: %s <filename>", os.Args[0])
+		return usageError()
+	}
+	return dump(os.Args[1])
+}
+
+// usageErr is returned for an invalid command line.
+type usageErr string
+
+func (e usageErr) Error() string {
+	return string(e)
+}
+
+// usageShown is set once a FlagSet has shown a command's usage
+// because its flags were invalid (see newFlagSet).
+var usageShown bool
+
+func usageError() error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{fmt.Sprintf("usage: %s <filename>", os.Args[0])}
+	for _, name := range names {
+		cmd := commands[name]
+		lines = append(lines, fmt.Sprintf("       %s %s %s\n           %s", os.Args[0], name, cmd.args, cmd.help))
 	}
+	lines = append(lines, "",
+		"Each <recording> may be a file, a directory (searched for .cptv files) or a",
+		"glob pattern. Commands taking several recordings process them in parallel",
+		"(see -j). The exit status is 1 if the command failed for any recording and",
+		"2 if the command line is invalid.")
+	return usageErr(strings.Join(lines, "\n"))
+}
+
+// commandUsage returns a usage error for a command.
+func commandUsage(name string) error {
+	return usageErr(fmt.Sprintf("usage: %s %s %s", os.Args[0], name, commands[name].args))
+}
 
-	fr, err := cptv.NewFileReader(os.Args[1])
+// dump shows some details of a recording.
+func dump(filename string) error {
+	fr, err := cptv.NewFileReader(filename)
 	if err != nil {
 		return err
 	}
@@ -45,8 +213,8 @@ func runMain() error {
 	fmt.Println("Device Name: ", fr.DeviceName())
 
 	// Read the frames and get a frame count. This is an illustration of
-	// frame reading - the r.FrameCount method will do the same thing (and
-	// will similarly leave the file pointer at EOF)
+	// frame reading - cptv.Probe will return the frame count without
+	// changing the read position.
 	frames := 0
 	frame := fr.Reader.EmptyFrame()
 	for {
@@ -65,3 +233,48 @@ func runMain() error {
 
 	return nil
 }
+
+// writeOutput creates a file using write. The file is written to a
+// temporary file first so that a partial file isn't left behind if
+// write fails.
+func writeOutput(name string, write func(w io.Writer) error) error {
+	return writeFileOutput(name, func(f *os.File) error {
+		bw := bufio.NewWriter(f)
+		if err := write(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+// writeFileOutput is like writeOutput but gives write direct access
+// to the (temporary) file, for example so that it can seek.
+func writeFileOutput(name string, write func(f *os.File) error) error {
+	tmp, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(aInfo, bInfo)
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvnpy"
+)
+
+func runNPY(args []string) error {
+	if len(args) != 2 {
+		return commandUsage("npy")
+	}
+	return writeNPYFile(args[0], args[1])
+}
+
+// writeNPYFile writes a .npy file, or a .npz file if outName has
+// that extension.
+func writeNPYFile(inName, outName string) error {
+	fr, err := cptv.NewFileReader(inName)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	write := cptvnpy.WriteNPY
+	if strings.ToLower(filepath.Ext(outName)) == ".npz" {
+		write = cptvnpy.WriteNPZ
+	}
+	return writeOutput(outName, func(w io.Writer) error {
+		return write(w, fr.Reader)
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvrender"
+)
+
+func runPNG(args []string) error {
+	flags := newFlagSet("png")
+	render := addRenderFlags(flags, "auto")
+	raw := flags.Bool("raw", false, "write the raw values as 16-bit greyscale instead of colouring them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return commandUsage("png")
+	}
+	n, cmap, err := render.parse()
+	if err != nil {
+		return err
+	}
+	inName, outDir := flags.Arg(0), flags.Arg(1)
+	count, err := writePNGFrames(inName, outDir, n, cmap, *raw)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d frames to %s\n", count, outDir)
+	return nil
+}
+
+// writePNGFrames writes each frame of a recording to a numbered PNG
+// file in outDir, returning the number of frames written. The range
+// of the whole recording is used if n is nil. If raw is true the
+// frames are written as 16-bit greyscale and n and cmap are unused.
+func writePNGFrames(inName, outDir string, n cptvrender.Normaliser, cmap *cptvrender.ColourMap, raw bool) (int, error) {
+	fr, err := cptv.NewFileReader(inName)
+	if err != nil {
+		return 0, err
+	}
+	defer fr.Close()
+	if n == nil && !raw {
+		if n, err = cptvrender.ClipRange(fr.Reader); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(inName), filepath.Ext(inName))
+	frame := fr.EmptyFrame()
+	var img *image.Paletted
+	count := 0
+	for ; ; count++ {
+		err := fr.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+
+		var out image.Image
+		if raw {
+			out = cptvrender.Gray16(frame)
+		} else {
+			if img == nil {
+				img = cptvrender.Render(frame, n, cmap)
+			} else {
+				cptvrender.RenderInto(img, frame, n)
+			}
+			out = img
+		}
+		name := filepath.Join(outDir, fmt.Sprintf("%s-%05d.png", base, count))
+		if err := writePNG(name, out); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func writePNG(name string, img image.Image) error {
+	return writeOutput(name, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
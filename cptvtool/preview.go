@@ -0,0 +1,77 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/go-cptv/cptvrender"
+)
+
+func runPreview(args []string) error {
+	flags := newFlagSet("preview")
+	render := addRenderFlags(flags, "clip")
+	format := flags.String("format", "", "gif or apng (default: from the output file's extension)")
+	every := flags.Int("every", 1, "only use every nth frame")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return commandUsage("preview")
+	}
+	inName, outName := flags.Arg(0), flags.Arg(1)
+	n, cmap, err := render.parse()
+	if err != nil {
+		return err
+	}
+	opts := cptvrender.PreviewOptions{
+		ColourMap:  cmap,
+		Normaliser: n,
+		Every:      *every,
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(outName)), ".")
+	}
+	if opts.Format, err = parsePreviewFormat(*format); err != nil {
+		return err
+	}
+	return writePreviewFile(inName, outName, opts)
+}
+
+// parsePreviewFormat returns the preview format with the given name.
+func parsePreviewFormat(name string) (cptvrender.PreviewFormat, error) {
+	switch name {
+	case "gif":
+		return cptvrender.GIF, nil
+	case "apng", "png":
+		return cptvrender.APNG, nil
+	}
+	return 0, fmt.Errorf("unknown preview format %q", name)
+}
+
+func writePreviewFile(inName, outName string, opts cptvrender.PreviewOptions) error {
+	fr, err := cptv.NewFileReader(inName)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptvrender.WritePreview(w, fr.Reader, opts)
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+func runRedact(args []string) error {
+	flags := newFlagSet("redact")
+	location := flags.String("location", "round:2", "latitude and longitude: keep, drop or round:<decimal places>")
+	altitude := flags.String("altitude", "drop", "altitude: keep, drop or round:<decimal places>")
+	locTimestamp := flags.String("loc-timestamp", "keep", "time of the location: keep or drop")
+	motionConfig := flags.String("motion-config", "keep", "motion detection config: keep or drop")
+	keepUnknown := flags.Bool("keep-unknown", false, "keep header and frame fields which aren't understood (dropped by default as they may identify the device)")
+	deviceName := flags.String("device-name", "hash", "device name: keep, drop or hash")
+	deviceID := flags.String("device-id", "hash", "device ID: keep, drop or hash")
+	cameraSerial := flags.String("camera-serial", "drop", "camera serial number: keep, drop or hash")
+	salt := flags.String("salt", "", "secret salt for hashed fields (required for hash)")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return commandUsage("redact")
+	}
+
+	var policy cptv.RedactionPolicy
+	var err error
+	rules := []struct {
+		dst   *cptv.Redaction
+		value string
+	}{
+		{&policy.Latitude, *location},
+		{&policy.Longitude, *location},
+		{&policy.Altitude, *altitude},
+		{&policy.LocTimestamp, *locTimestamp},
+		{&policy.MotionConfig, *motionConfig},
+		{&policy.DeviceName, *deviceName},
+		{&policy.DeviceID, *deviceID},
+		{&policy.CameraSerial, *cameraSerial},
+	}
+	hashed := false
+	for _, rule := range rules {
+		*rule.dst, err = parseRedaction(rule.value)
+		if err != nil {
+			return err
+		}
+		hashed = hashed || rule.dst.Action == cptv.RedactHash
+	}
+	if hashed && *salt == "" {
+		return errors.New("-salt is required to hash fields")
+	}
+	policy.Salt = []byte(*salt)
+	policy.KeepUnknownFields = *keepUnknown
+	if err := policy.Apply(new(cptv.Header)); err != nil {
+		return err
+	}
+
+	recs, err := findRecordings(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	outDir := flags.Arg(0)
+	return forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		outName := filepath.Join(outDir, rec.relName)
+		if err := redactFile(rec.name, outName, policy); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s -> %s\n", rec.name, outName)
+		return nil
+	})
+}
+
+// parseRedaction parses a redaction given on the command line.
+func parseRedaction(s string) (cptv.Redaction, error) {
+	switch {
+	case s == "keep":
+		return cptv.Redaction{Action: cptv.RedactKeep}, nil
+	case s == "drop":
+		return cptv.Redaction{Action: cptv.RedactDrop}, nil
+	case s == "hash":
+		return cptv.Redaction{Action: cptv.RedactHash}, nil
+	case strings.HasPrefix(s, "round:"):
+		places, err := strconv.Atoi(strings.TrimPrefix(s, "round:"))
+		if err != nil {
+			return cptv.Redaction{}, fmt.Errorf("invalid redaction %q", s)
+		}
+		return cptv.Redaction{Action: cptv.RedactRound, Places: places}, nil
+	}
+	return cptv.Redaction{}, fmt.Errorf("invalid redaction %q", s)
+}
+
+func redactFile(inName, outName string, policy cptv.RedactionPolicy) error {
+	if sameFile(inName, outName) {
+		return errors.New("the output file must be different to the input file")
+	}
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(outName), 0755); err != nil {
+		return err
+	}
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptv.Redact(in, w, policy)
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvrender"
+)
+
+// renderFlags are the command line options controlling how frames
+// are rendered.
+type renderFlags struct {
+	colourMap  string
+	valueRange string
+}
+
+func addRenderFlags(flags *flag.FlagSet, defaultRange string) *renderFlags {
+	f := new(renderFlags)
+	flags.StringVar(&f.colourMap, "colour-map", cptvrender.Ironbow.Name,
+		fmt.Sprintf("colour map (%s)", strings.Join(cptvrender.ColourMapNames(), ", ")))
+	flags.StringVar(&f.valueRange, "range", defaultRange,
+		"range of values to colour: clip (the whole recording), auto (each frame), <lo>:<hi> or percentile:<low>:<high>")
+	return f
+}
+
+// parse returns the normaliser and colour map selected. The
+// normaliser is nil if the range of the whole recording should be used
+// (see cptvrender.ClipRange).
+func (f *renderFlags) parse() (cptvrender.Normaliser, *cptvrender.ColourMap, error) {
+	cmap, err := cptvrender.LookupColourMap(f.colourMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.valueRange == "clip" {
+		return nil, cmap, nil
+	}
+	n, err := cptvrender.ParseNormaliser(f.valueRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, cmap, nil
+}
+
+// newFlagSet returns a FlagSet for a command which shows the
+// command's usage.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		usageShown = true
+		fmt.Fprintln(flags.Output(), commandUsage(name))
+		flags.PrintDefaults()
+	}
+	return flags
+}
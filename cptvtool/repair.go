@@ -0,0 +1,60 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+func runRepair(args []string) error {
+	if len(args) != 2 {
+		return commandUsage("repair")
+	}
+	inName, outName := args[0], args[1]
+	if sameFile(inName, outName) {
+		return errors.New("the output file must be different to the damaged file")
+	}
+
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var result cptv.SalvageResult
+	err = writeOutput(outName, func(w io.Writer) error {
+		var err error
+		result, err = cptv.Salvage(in, w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("recovered %d frames", result.Frames)
+	if result.Skipped > 0 {
+		fmt.Printf(", skipped %d frames which couldn't be decoded", result.Skipped)
+	}
+	fmt.Println()
+	if result.Err != nil {
+		fmt.Println("stopped reading:", result.Err)
+	}
+	return nil
+}
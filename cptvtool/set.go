@@ -0,0 +1,160 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// headerSetters sets header fields from their command line values.
+var headerSetters = map[string]func(h *cptv.Header, v string) error{
+	"timestamp": func(h *cptv.Header, v string) error {
+		return setTime(&h.Timestamp, v)
+	},
+	"device-name": func(h *cptv.Header, v string) error {
+		h.DeviceName = v
+		return nil
+	},
+	"device-id": func(h *cptv.Header, v string) error {
+		return setUint(&h.DeviceID, v, 32)
+	},
+	"camera-serial": func(h *cptv.Header, v string) error {
+		return setUint(&h.CameraSerial, v, 32)
+	},
+	"firmware": func(h *cptv.Header, v string) error {
+		h.Firmware = v
+		return nil
+	},
+	"brand": func(h *cptv.Header, v string) error {
+		h.Brand = v
+		return nil
+	},
+	"model": func(h *cptv.Header, v string) error {
+		h.Model = v
+		return nil
+	},
+	"preview-secs": func(h *cptv.Header, v string) error {
+		return setUint(&h.PreviewSecs, v, 8)
+	},
+	"fps": func(h *cptv.Header, v string) error {
+		return setUint(&h.FPS, v, 8)
+	},
+	"latitude": func(h *cptv.Header, v string) error {
+		h.Present |= cptv.HasLatitude
+		return setFloat(&h.Latitude, v)
+	},
+	"longitude": func(h *cptv.Header, v string) error {
+		h.Present |= cptv.HasLongitude
+		return setFloat(&h.Longitude, v)
+	},
+	"altitude": func(h *cptv.Header, v string) error {
+		h.Present |= cptv.HasAltitude
+		return setFloat(&h.Altitude, v)
+	},
+	"accuracy": func(h *cptv.Header, v string) error {
+		h.Present |= cptv.HasAccuracy
+		return setFloat(&h.Accuracy, v)
+	},
+	"loc-timestamp": func(h *cptv.Header, v string) error {
+		h.Present |= cptv.HasLocTimestamp
+		return setTime(&h.LocTimestamp, v)
+	},
+}
+
+func runSet(args []string) error {
+	if len(args) < 3 {
+		return commandUsage("set")
+	}
+	inName, outName := args[0], args[1]
+
+	// Check all the settings before changing anything.
+	var settings [][2]string
+	for _, arg := range args[2:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return commandUsage("set")
+		}
+		set, ok := headerSetters[parts[0]]
+		if !ok {
+			return fmt.Errorf("unknown field %q (known fields: %s)", parts[0], headerFieldNames())
+		}
+		if err := set(new(cptv.Header), parts[1]); err != nil {
+			return fmt.Errorf("invalid %s: %v", parts[0], err)
+		}
+		settings = append(settings, [2]string{parts[0], parts[1]})
+	}
+
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// The output is written to a temporary file first so it may
+	// replace the input file.
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptv.RewriteHeader(in, w, func(h *cptv.Header) {
+			for _, s := range settings {
+				headerSetters[s[0]](h, s[1])
+			}
+		})
+	})
+}
+
+func headerFieldNames() string {
+	names := make([]string, 0, len(headerSetters))
+	for name := range headerSetters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// setUint sets a field which is written to the header as an unsigned
+// integer of the given size, rejecting values which don't fit.
+func setUint(dst *int, v string, bits int) error {
+	i, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		return fmt.Errorf("%q isn't a whole number from 0 to %d", v, uint64(1)<<uint(bits)-1)
+	}
+	*dst = int(i)
+	return nil
+}
+
+func setFloat(dst *float32, v string) error {
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return err
+	}
+	*dst = float32(f)
+	return nil
+}
+
+func setTime(dst *time.Time, v string) error {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return err
+	}
+	*dst = t
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetUint(t *testing.T) {
+	tests := []struct {
+		v    string
+		bits int
+		want int
+		ok   bool
+	}{
+		{"0", 8, 0, true},
+		{"255", 8, 255, true},
+		{"256", 8, 0, false},
+		{"-1", 8, 0, false},
+		{"4294967295", 32, 4294967295, true},
+		{"4294967296", 32, 0, false},
+		{"1.5", 32, 0, false},
+		{"", 32, 0, false},
+	}
+	for _, test := range tests {
+		var got int
+		err := setUint(&got, test.v, test.bits)
+		if test.ok {
+			assert.NoError(t, err, test.v)
+		} else {
+			assert.Error(t, err, test.v)
+		}
+		assert.Equal(t, test.want, got, test.v)
+	}
+}
+
+func TestSetUintMessage(t *testing.T) {
+	var v int
+	err := setUint(&v, "300", 8)
+	assert.EqualError(t, err, `"300" isn't a whole number from 0 to 255`)
+}
+
+func TestHeaderSetters(t *testing.T) {
+	var h cptv.Header
+	assert.NoError(t, headerSetters["fps"](&h, "9"))
+	assert.NoError(t, headerSetters["device-id"](&h, "4294967295"))
+	assert.NoError(t, headerSetters["altitude"](&h, "12.5"))
+	assert.Equal(t, 9, h.FPS)
+	assert.Equal(t, 4294967295, h.DeviceID)
+	assert.Equal(t, float32(12.5), h.Altitude)
+	assert.True(t, h.Present.Has(cptv.HasAltitude))
+
+	assert.Error(t, headerSetters["preview-secs"](&h, "256"))
+	assert.Error(t, headerSetters["camera-serial"](&h, "4294967296"))
+	assert.Error(t, headerSetters["timestamp"](&h, "yesterday"))
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+func runVerifySignature(args []string) error {
+	if len(args) < 2 {
+		return commandUsage("verify-signature")
+	}
+	key, err := readPublicKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, filename := range args[1:] {
+		if err := verifyFileSignature(filename, key); err != nil {
+			fmt.Printf("%s: %v\n", filename, err)
+			failed++
+		} else {
+			fmt.Printf("%s: OK\n", filename)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d recordings failed verification", failed, len(args)-1)
+	}
+	return nil
+}
+
+func verifyFileSignature(filename string, key ed25519.PublicKey) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cptv.VerifySignature(f, key)
+}
+
+// readPublicKey reads an Ed25519 public key from a file. The key may
+// be stored as raw bytes or encoded as hex or base64.
+func readPublicKey(filename string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(data), nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(key), nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(key), nil
+	}
+	return nil, fmt.Errorf("%s doesn't contain an Ed25519 public key", filename)
+}
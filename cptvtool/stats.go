@@ -0,0 +1,191 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+// recordingStats is calculated by the stats command for each
+// recording. The pixel and temperature statistics exclude the
+// background frame.
+type recordingStats struct {
+	File       string  `json:"file"`
+	Bytes      int64   `json:"bytes"`
+	Frames     int     `json:"frames"`
+	DurationMs int64   `json:"duration_ms"`
+	Min        uint16  `json:"min"`
+	Max        uint16  `json:"max"`
+	Mean       float64 `json:"mean"`
+	MeanTempC  float64 `json:"mean_temp_c"`
+	FFCs       int     `json:"ffcs"` // flat field corrections during the recording
+}
+
+// statsTotal sums the stats of all the recordings.
+type statsTotal struct {
+	Recordings int   `json:"recordings"`
+	Bytes      int64 `json:"bytes"`
+	Frames     int   `json:"frames"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+func runStats(args []string) error {
+	flags := newFlagSet("stats")
+	jsonOut := flags.Bool("json", false, "write JSON")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("stats")
+	}
+	recs, err := findRecordings(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	// The results are shown once all the recordings have been read so
+	// that the table's columns line up.
+	var mu sync.Mutex
+	stats := make(map[recording]*recordingStats)
+	err = forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		s, err := readStats(rec.name)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		stats[rec] = s
+		mu.Unlock()
+		return nil
+	})
+	list := []*recordingStats{}
+	var total statsTotal
+	for _, rec := range recs {
+		s, ok := stats[rec]
+		if !ok {
+			continue
+		}
+		list = append(list, s)
+		total.Recordings++
+		total.Bytes += s.Bytes
+		total.Frames += s.Frames
+		total.DurationMs += s.DurationMs
+	}
+
+	if *jsonOut {
+		out := struct {
+			Recordings []*recordingStats `json:"recordings"`
+			Total      statsTotal        `json:"total"`
+		}{list, total}
+		if jsonErr := json.NewEncoder(os.Stdout).Encode(out); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\tframes\tduration\tbytes\tmin\tmax\tmean\ttemp_c\tffcs")
+	for _, s := range list {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%d\t%.1f\t%.2f\t%d\n",
+			s.File, s.Frames, msDuration(s.DurationMs), s.Bytes, s.Min, s.Max, s.Mean, s.MeanTempC, s.FFCs)
+	}
+	fmt.Fprintf(tw, "total (%d recordings)\t%d\t%s\t%d\n",
+		total.Recordings, total.Frames, msDuration(total.DurationMs), total.Bytes)
+	if flushErr := tw.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+func msDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func readStats(name string) (*recordingStats, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	summary, err := cptv.Probe(f)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cptv.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &recordingStats{
+		File:       name,
+		Bytes:      info.Size(),
+		Frames:     summary.FrameCount,
+		DurationMs: summary.Duration.Milliseconds(),
+		Min:        math.MaxUint16,
+	}
+	var sum, tempSum float64
+	pixels, frames := 0, 0
+	var lastFFC time.Duration
+	frame := r.EmptyFrame()
+	for {
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if frame.Status.BackgroundFrame {
+			continue
+		}
+		for _, row := range frame.Pix {
+			for _, v := range row {
+				if v < s.Min {
+					s.Min = v
+				}
+				if v > s.Max {
+					s.Max = v
+				}
+				sum += float64(v)
+			}
+			pixels += len(row)
+		}
+		tempSum += frame.Status.TempC
+		if frames > 0 && frame.Status.LastFFCTime != lastFFC {
+			s.FFCs++
+		}
+		lastFFC = frame.Status.LastFFCTime
+		frames++
+	}
+	if pixels == 0 {
+		s.Min = 0
+	} else {
+		s.Mean = sum / float64(pixels)
+		s.MeanTempC = tempSum / float64(frames)
+	}
+	return s, nil
+}
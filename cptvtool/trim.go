@@ -0,0 +1,78 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+func runTrim(args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return commandUsage("trim")
+	}
+	inName, outName := args[0], args[1]
+	if sameFile(inName, outName) {
+		return errors.New("the output file must be different to the input file")
+	}
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid start frame: %v", args[2])
+	}
+	end := math.MaxInt32
+	if len(args) == 4 {
+		end, err = strconv.Atoi(args[3])
+		if err != nil {
+			return fmt.Errorf("invalid end frame: %v", args[3])
+		}
+	}
+
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptv.Trim(in, w, start, end)
+	})
+}
+
+func runConcat(args []string) error {
+	if len(args) < 2 {
+		return commandUsage("concat")
+	}
+	outName, inNames := args[0], args[1:]
+	var inputs []io.Reader
+	for _, name := range inNames {
+		if sameFile(name, outName) {
+			return errors.New("the output file must be different to the input files")
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	return writeOutput(outName, func(w io.Writer) error {
+		return cptv.Concat(w, inputs...)
+	})
+}
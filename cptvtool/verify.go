@@ -0,0 +1,80 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TheCacophonyProject/go-cptv"
+)
+
+func runVerify(args []string) error {
+	flags := newFlagSet("verify")
+	keyFile := flags.String("public-key", "", "also check the recordings were signed with this Ed25519 public key")
+	jobs := addJobsFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("verify")
+	}
+	var key ed25519.PublicKey
+	if *keyFile != "" {
+		var err error
+		if key, err = readPublicKey(*keyFile); err != nil {
+			return err
+		}
+	}
+	recs, err := findRecordings(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	return forEachRecording(recs, *jobs, func(rec recording, out io.Writer) error {
+		result, err := verifyFile(rec.name, key)
+		if err != nil {
+			return err
+		}
+		if !result.OK() {
+			for _, problem := range result.Damaged {
+				fmt.Fprintf(out, "%s: frame %d: %v\n", rec.name, problem.Frame, problem.Err)
+			}
+			return fmt.Errorf("%d of %d frames are damaged", len(result.Damaged), result.Frames)
+		}
+		fmt.Fprintf(out, "%s: OK (%d frames, %d with checksums)\n", rec.name, result.Frames, result.Checksums)
+		return nil
+	})
+}
+
+// verifyFile checks that a recording can be read and, if key isn't
+// nil, that it was signed with key.
+func verifyFile(name string, key ed25519.PublicKey) (cptv.VerifyResult, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return cptv.VerifyResult{}, err
+	}
+	defer f.Close()
+	result, err := cptv.Verify(f)
+	if err != nil || key == nil {
+		return result, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return result, err
+	}
+	return result, cptv.VerifySignature(f, key)
+}
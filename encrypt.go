@@ -0,0 +1,320 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// encryptedMagic identifies the preamble of an encrypted CPTV
+	// file. It takes the place of the CPTV magic so that readers
+	// which don't support encryption fail the magic check.
+	encryptedMagic         = "CPTE"
+	encryptionVersion byte = 0x01
+
+	// encryptedChunkSize is the amount of plaintext encrypted in each
+	// chunk. Readers reject larger chunk sizes, which they would have
+	// to allocate before the preamble could be authenticated.
+	encryptedChunkSize = 64 * 1024
+
+	// Preamble field keys
+	EncryptionKeyID     byte = 'K'
+	EncryptionNonce     byte = 'N'
+	EncryptionChunkSize byte = 'C'
+)
+
+var (
+	// ErrEncrypted is returned when reading an encrypted recording
+	// without a key provider (see WithKeys).
+	ErrEncrypted = errors.New("recording is encrypted")
+
+	// ErrDecryption is returned when an encrypted recording can't be
+	// decrypted, because the key is wrong or the recording has been
+	// modified.
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// KeyProvider returns the AES key with the given ID.
+type KeyProvider func(keyID string) ([]byte, error)
+
+// WithEncryption makes the Writer encrypt the recording using AES-GCM
+// with key, which must be 16, 24 or 32 bytes long. keyID is stored
+// unencrypted at the start of the recording so that readers can find
+// the key (see WithKeys).
+//
+// The footer is also encrypted so Probe returns ErrEncrypted for
+// encrypted recordings.
+func WithEncryption(keyID string, key []byte) WriterOption {
+	return func(w *Writer) {
+		enc, err := newEncryptingWriter(w.bldr.out, keyID, key)
+		if err != nil {
+			w.err = err
+			return
+		}
+		w.enc = enc
+		w.bldr.out = enc
+		w.bldr.w.Reset(enc)
+	}
+}
+
+// WithKeys allows the Reader to read encrypted recordings, using keys
+// to look up the key for a recording.
+func WithKeys(keys KeyProvider) ReaderOption {
+	return func(r *Reader) {
+		r.keys = keys
+	}
+}
+
+// openStream returns the uncompressed CPTV stream from src,
+// decrypting it if required.
+func (r *Reader) openStream(src io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	// Only read the first gzip member to start with; for encrypted
+	// recordings this is the preamble.
+	gr.Multistream(false)
+	magicBuf := make([]byte, len(encryptedMagic))
+	n, _ := io.ReadFull(gr, magicBuf)
+	if string(magicBuf[:n]) != encryptedMagic {
+		gr.Multistream(true)
+		return io.MultiReader(bytes.NewReader(magicBuf[:n]), gr), nil
+	}
+
+	if r.keys == nil {
+		return nil, ErrEncrypted
+	}
+	preamble := nReader{gr}
+	v, err := preamble.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", v)
+	}
+	fields, err := readFieldsN(preamble, false)
+	if err != nil {
+		return nil, err
+	}
+	// Read the rest of the preamble member, including the gzip
+	// trailer. The encrypted data follows.
+	if _, err := io.Copy(ioutil.Discard, gr); err != nil {
+		return nil, err
+	}
+
+	keyID, err := fields.String(EncryptionKeyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ok := fields[EncryptionNonce]
+	if !ok {
+		return nil, &FieldError{Code: EncryptionNonce, Want: -1, Got: -1}
+	}
+	chunkSize, err := fields.Uint32(EncryptionChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize == 0 || chunkSize > encryptedChunkSize {
+		return nil, fmt.Errorf("unsupported encryption chunk size %d", chunkSize)
+	}
+	key, err := r.keys(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, &FieldError{Code: EncryptionNonce, Want: aead.NonceSize(), Got: len(nonce)}
+	}
+	plain := &decryptingReader{
+		r:      br,
+		aead:   aead,
+		nonce:  nonce,
+		sealed: make([]byte, int(chunkSize)+aead.Overhead()),
+	}
+	return gzip.NewReader(bufio.NewReader(plain))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for a chunk: the recording's nonce
+// with the chunk number XORed into its last 8 bytes.
+func chunkNonce(dst, nonce []byte, chunk uint64) []byte {
+	dst = append(dst[:0], nonce...)
+	tail := dst[len(dst)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^chunk)
+	return dst
+}
+
+// chunkData returns the additional authenticated data for a
+// chunk. Marking the final chunk allows truncation to be detected.
+func chunkData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func newEncryptingWriter(w io.Writer, keyID string, key []byte) (*encryptingWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The preamble is a gzip member so that readers which don't
+	// support encryption fail the magic check.
+	fields := NewFieldWriter()
+	if err := fields.String(EncryptionKeyID, keyID); err != nil {
+		return nil, err
+	}
+	fields.Raw(EncryptionNonce, nonce)
+	fields.Uint32(EncryptionChunkSize, encryptedChunkSize)
+	fieldData, numFields := fields.Bytes()
+	preamble := new(bytes.Buffer)
+	gw, err := gzip.NewWriterLevel(preamble, gzip.NoCompression)
+	if err != nil {
+		return nil, err
+	}
+	gw.Write([]byte(encryptedMagic))
+	gw.Write([]byte{encryptionVersion, byte(numFields)})
+	gw.Write(fieldData)
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		w:        w,
+		aead:     aead,
+		nonce:    nonce,
+		preamble: preamble.Bytes(),
+	}, nil
+}
+
+// encryptingWriter encrypts data in chunks of encryptedChunkSize
+// bytes. The final chunk is always shorter than encryptedChunkSize
+// (it may be empty) and is only written by Close.
+type encryptingWriter struct {
+	w        io.Writer
+	aead     cipher.AEAD
+	nonce    []byte
+	preamble []byte
+	buf      []byte
+	sealed   []byte
+	chunk    uint64
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) >= encryptedChunkSize {
+		if err := e.writeChunk(e.buf[:encryptedChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[:copy(e.buf, e.buf[encryptedChunkSize:])]
+	}
+	return len(p), nil
+}
+
+// Close writes the final chunk.
+func (e *encryptingWriter) Close() error {
+	return e.writeChunk(e.buf, true)
+}
+
+func (e *encryptingWriter) writeChunk(plain []byte, final bool) error {
+	if e.preamble != nil {
+		if _, err := e.w.Write(e.preamble); err != nil {
+			return err
+		}
+		e.preamble = nil
+	}
+	nonce := chunkNonce(nil, e.nonce, e.chunk)
+	e.sealed = e.aead.Seal(e.sealed[:0], nonce, plain, chunkData(final))
+	e.chunk++
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// decryptingReader reverses encryptingWriter.
+type decryptingReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	nonce  []byte
+	sealed []byte
+	plain  []byte
+	chunk  uint64
+	done   bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) readChunk() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	final := false
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		final = true
+	case io.EOF:
+		// The final chunk is missing.
+		return io.ErrUnexpectedEOF
+	default:
+		return err
+	}
+	nonce := chunkNonce(nil, d.nonce, d.chunk)
+	plain, err := d.aead.Open(d.sealed[:0:0], nonce, d.sealed[:n], chunkData(final))
+	if err != nil {
+		return ErrDecryption
+	}
+	d.plain = plain
+	d.chunk++
+	d.done = final
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testKeys(keyID string) ([]byte, error) {
+	if keyID != "device-key-1" {
+		return nil, errors.New("unknown key")
+	}
+	return testKey, nil
+}
+
+func TestEncryption(t *testing.T) {
+	camera := new(TestCamera)
+	frames, data := writeEncryptedRecording(t, camera, 20, WithFooter())
+
+	// Make sure that more than one chunk is used.
+	require.True(t, len(data) > encryptedChunkSize)
+
+	r, err := NewReader(bytes.NewReader(data), WithKeys(testKeys))
+	require.NoError(t, err)
+	assert.Equal(t, "nz42", r.DeviceName())
+	frameD := r.EmptyFrame()
+	for _, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+	assert.NotNil(t, r.parser.Section(FooterSection))
+
+	// Seeking requires the recording to be decrypted again.
+	require.NoError(t, r.SeekFrame(2))
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frames[2], frameD)
+}
+
+func TestEncryptionNoKeys(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 2)
+
+	_, err := NewReader(bytes.NewReader(data))
+	assert.Equal(t, ErrEncrypted, err)
+
+	// Readers which don't understand the encryption preamble fail the
+	// magic check.
+	p, err := NewParser(bytes.NewReader(data))
+	require.NoError(t, err)
+	_, err = p.Header()
+	assert.Equal(t, ErrEncrypted, err)
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 2)
+
+	wrongKey := func(string) ([]byte, error) {
+		return []byte("fedcba9876543210fedcba9876543210"), nil
+	}
+	_, err := NewReader(bytes.NewReader(data), WithKeys(wrongKey))
+	assert.Equal(t, ErrDecryption, err)
+
+	noKey := func(string) ([]byte, error) {
+		return nil, errors.New("unknown key")
+	}
+	_, err = NewReader(bytes.NewReader(data), WithKeys(noKey))
+	assert.EqualError(t, err, "unknown key")
+}
+
+func TestEncryptionModified(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 20)
+
+	data[len(data)-20] ^= 1
+	r, err := NewReader(bytes.NewReader(data), WithKeys(testKeys))
+	require.NoError(t, err)
+	_, err = r.FrameCount()
+	assert.Equal(t, ErrDecryption, err)
+}
+
+func TestEncryptionTruncated(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 20)
+
+	// Remove the final chunk.
+	enc, err := newEncryptingWriter(ioutil.Discard, "device-key-1", testKey)
+	require.NoError(t, err)
+	sealedSize := encryptedChunkSize + enc.aead.Overhead()
+	finalSize := (len(data) - len(enc.preamble)) % sealedSize
+	r, err := NewReader(bytes.NewReader(data[:len(data)-finalSize]), WithKeys(testKeys))
+	require.NoError(t, err)
+	_, err = r.FrameCount()
+	assert.Equal(t, ErrTruncatedFrame, err)
+}
+
+func TestEncryptionChunkSize(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 2)
+	enc, err := newEncryptingWriter(ioutil.Discard, "device-key-1", testKey)
+	require.NoError(t, err)
+	preambleSize := len(enc.preamble)
+
+	for _, chunkSize := range []uint32{0, encryptedChunkSize + 1, math.MaxUint32} {
+		preamble := gunzipBytes(t, data[:preambleSize])
+		i := bytes.Index(preamble, []byte{4, EncryptionChunkSize})
+		require.True(t, i > 0)
+		binary.LittleEndian.PutUint32(preamble[i+2:], chunkSize)
+		modified := append(gzipBytes(t, preamble), data[preambleSize:]...)
+
+		_, err := NewReader(bytes.NewReader(modified), WithKeys(testKeys))
+		assert.EqualError(t, err, fmt.Sprintf("unsupported encryption chunk size %d", chunkSize))
+	}
+}
+
+func TestEncryptionBadKey(t *testing.T) {
+	camera := new(TestCamera)
+	w := NewWriter(ioutil.Discard, camera, WithEncryption("short", []byte("too short")))
+	assert.Error(t, w.WriteHeader(Header{}))
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cptv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "encrypted.cptv")
+
+	camera := new(TestCamera)
+	fw, err := NewFileWriter(filename, camera, WithEncryption("device-key-1", testKey))
+	require.NoError(t, err)
+	require.NoError(t, fw.WriteHeader(Header{DeviceName: "nz42"}))
+	frame := makeTestFrame(camera)
+	require.NoError(t, fw.WriteFrame(frame))
+	fw.Close()
+
+	fr, err := NewFileReader(filename, WithKeys(testKeys))
+	require.NoError(t, err)
+	defer fr.Close()
+	frameD := fr.EmptyFrame()
+	require.NoError(t, fr.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+}
+
+func writeEncryptedRecording(t *testing.T, camera cptvframe.CameraSpec, n int, opts ...WriterOption) ([]*cptvframe.Frame, []byte) {
+	var frames []*cptvframe.Frame
+	buf := new(bytes.Buffer)
+	opts = append(opts, WithEncryption("device-key-1", testKey))
+	w := NewWriter(buf, camera, opts...)
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42"}))
+	// Noisy frames don't compress well so that the recording spans
+	// several chunks.
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		frame := cptvframe.NewFrame(camera)
+		for _, row := range frame.Pix {
+			for x := range row {
+				row[x] = uint16(rnd.Intn(1 << 12))
+			}
+		}
+		require.NoError(t, w.WriteFrame(frame))
+		frames = append(frames, frame)
+	}
+	require.NoError(t, w.Close())
+	return frames, buf.Bytes()
+}
+
+func TestEncryptionModifiedReadFrame(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeEncryptedRecording(t, camera, 20)
+
+	data[len(data)-20] ^= 1
+	r, err := NewReader(bytes.NewReader(data), WithKeys(testKeys))
+	require.NoError(t, err)
+	frame := r.EmptyFrame()
+	for err == nil {
+		err = r.ReadFrame(frame)
+	}
+	assert.True(t, errors.Is(err, ErrDecryption), "got %v", err)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when reading CPTV files. Errors may be wrapped with
+// more detail so they should be checked for using errors.Is.
+var (
+	// ErrBadMagic is returned when a stream doesn't start with the
+	// CPTV magic bytes.
+	ErrBadMagic = errors.New("magic not found")
+
+	// ErrUnsupportedVersion is returned for CPTV versions this
+	// package can't read.
+	ErrUnsupportedVersion = errors.New("unsupported CPTV version")
+
+	// ErrTruncatedFrame is returned when a stream ends part way
+	// through a frame.
+	ErrTruncatedFrame = errors.New("truncated frame")
+
+	// ErrFrameChecksum is returned when a frame's data doesn't match
+	// its checksum (see WithFrameChecksums).
+	ErrFrameChecksum = errors.New("frame checksum mismatch")
+
+	// ErrCorruptFrame is returned by a strict Reader for frames which
+	// don't match the header (see Strict).
+	ErrCorruptFrame = errors.New("corrupt frame")
+
+	// ErrFieldTooLong is returned for fields longer than MaxFieldLen.
+	ErrFieldTooLong = errors.New("field too long")
+
+	// ErrBadResolution is returned by a strict Reader for headers
+	// with a resolution of zero or more than MaxFramePixels pixels
+	// (see Strict).
+	ErrBadResolution = errors.New("invalid resolution")
+
+	// ErrTooManyFields is returned when writing a section with more
+	// than 255 fields.
+	ErrTooManyFields = errors.New("too many fields in section")
+)
+
+// FieldError is returned when a field is missing or has the wrong
+// length for its type.
+type FieldError struct {
+	Code byte
+	// Want is the expected length of the field, or -1 if any length
+	// is valid.
+	Want int
+	// Got is the length of the field, or -1 if it is missing.
+	Got int
+}
+
+func (e *FieldError) Error() string {
+	if e.Got < 0 {
+		return fmt.Sprintf("field %q not found", e.Code)
+	}
+	return fmt.Sprintf("field %q has length %d, expected %d", e.Code, e.Got, e.Want)
+}
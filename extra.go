@@ -0,0 +1,122 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// headerFieldCodes are the header field codes understood by this
+// package. Other header fields are returned in Header.Extra or
+// Header.Reserved.
+var headerFieldCodes = codeSet(
+	Timestamp, XResolution, YResolution, Compression, DeviceName,
+	DeviceID, MotionConfig, PreviewSecs, Latitude, Longitude,
+	LocTimestamp, Altitude, Accuracy, FPS, Model, Brand, Firmware,
+	CameraSerial, BackgroundFrame,
+)
+
+// frameFieldCodes are the frame field codes understood by this
+// package. Other frame fields are returned in cptvframe.Frame.Extra
+// or cptvframe.Frame.Reserved.
+var frameFieldCodes = codeSet(
+	TimeOn, BitWidth, FrameSize, LastFFCTime, TempC, LastFFCTempC,
+	BackgroundFrame, Keyframe, FrameCount, FFCState, FrameMean,
+	FrameCRC,
+)
+
+// IsFrameField returns true if code is a frame field code understood
+// by this package, rather than one returned in cptvframe.Frame.Extra
+// or cptvframe.Frame.Reserved.
+func IsFrameField(code byte) bool {
+	return frameFieldCodes[code]
+}
+
+func codeSet(codes ...byte) map[byte]bool {
+	set := make(map[byte]bool)
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+// extraFields returns the fields in f which aren't in known, split
+// into application defined fields (codes from UserFieldMin up) and
+// fields reserved for the CPTV specification. Each is nil if there
+// are no such fields.
+func extraFields(f Fields, known map[byte]bool) (extra, reserved map[byte][]byte) {
+	for code, data := range f {
+		if known[code] {
+			continue
+		}
+		if code >= UserFieldMin {
+			if extra == nil {
+				extra = make(map[byte][]byte)
+			}
+			extra[code] = data
+		} else {
+			if reserved == nil {
+				reserved = make(map[byte][]byte)
+			}
+			reserved[code] = data
+		}
+	}
+	return extra, reserved
+}
+
+// writeExtraFields adds application defined fields to fields, in
+// code order. Only codes from UserFieldMin up may be used.
+func writeExtraFields(fields *FieldWriter, extra map[byte][]byte) error {
+	codes := make([]int, 0, len(extra))
+	for code := range extra {
+		if code < UserFieldMin {
+			return fmt.Errorf("extra field code %d is reserved (codes from %d up may be used)", code, UserFieldMin)
+		}
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		if err := fields.Raw(byte(code), extra[byte(code)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeReservedFields adds fields reserved for the CPTV specification
+// which aren't understood by this package, such as fields added by a
+// newer version of the specification, in code order. Codes in known,
+// which this package writes itself, and codes from UserFieldMin up
+// can't be used.
+func writeReservedFields(fields *FieldWriter, reserved map[byte][]byte, known map[byte]bool) error {
+	codes := make([]int, 0, len(reserved))
+	for code := range reserved {
+		if code >= UserFieldMin {
+			return fmt.Errorf("reserved field code %d is for application defined fields (use Extra)", code)
+		}
+		if known[code] {
+			return fmt.Errorf("reserved field code %d is already written by this package", code)
+		}
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		if err := fields.Raw(byte(code), reserved[byte(code)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,8 +15,8 @@
 package cptv
 
 import (
+	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -25,13 +25,38 @@ import (
 	"github.com/TheCacophonyProject/lepton3"
 )
 
+// longFieldLen is the field length byte which indicates a long field
+// (from CPTV version 3). The field's length follows as a uint32.
+const longFieldLen = 255
+
+// maxFieldCount is the most fields a section can have, as the count
+// is stored in a byte.
+const maxFieldCount = 255
+
+// MaxFieldLen is the longest field which will be read or written. It
+// protects readers from allocating huge amounts of memory for
+// damaged or malicious files.
+const MaxFieldLen = 16 << 20
+
 // ReadFields reads the fields for a CPTV section, returning a new
-// Fields instance.
+// Fields instance. Long fields (CPTV version 3) are not supported; use
+// ReadVersionFields for sections which may have them.
 func ReadFields(r io.Reader) (Fields, error) {
-	return readFieldsN(nReader{r})
+	return readFieldsN(nReader{r}, false)
 }
 
-func readFieldsN(r nReader) (Fields, error) {
+// ReadVersionFields reads the fields for a section of a CPTV file
+// with the given version, including any long fields.
+// ErrUnsupportedVersion is returned for versions this package can't
+// read.
+func ReadVersionFields(r io.Reader, version int) (Fields, error) {
+	if version < 1 || version > int(maxVersion) {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	return readFieldsN(nReader{r}, version >= int(longFieldsVersion))
+}
+
+func readFieldsN(r nReader, long bool) (Fields, error) {
 	fieldCount, err := r.ReadByteInt()
 	if err != nil {
 		return nil, err
@@ -42,11 +67,27 @@ func readFieldsN(r nReader) (Fields, error) {
 		if err != nil {
 			return nil, err
 		}
+		if long && size == longFieldLen {
+			sizeBytes, err := r.ReadN(4)
+			if err != nil {
+				return nil, err
+			}
+			longSize := binary.LittleEndian.Uint32(sizeBytes)
+			if longSize > MaxFieldLen {
+				return nil, fmt.Errorf("field length %d: %w", longSize, ErrFieldTooLong)
+			}
+			size = int(longSize)
+		}
 		code, err := r.ReadByte()
 		if err != nil {
 			return nil, err
 		}
-		data, err := r.ReadN(size)
+		var data []byte
+		if size < longFieldLen {
+			data, err = r.ReadN(size)
+		} else {
+			data, err = readLongField(r, size)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -55,6 +96,20 @@ func readFieldsN(r nReader) (Fields, error) {
 	return f, nil
 }
 
+// readLongField reads the data of a long field. The data is read
+// incrementally so that a truncated stream fails before a large
+// buffer has been allocated.
+func readLongField(r nReader, size int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := io.CopyN(buf, r.Reader, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // Fields maps from field key -> field data
 type Fields map[byte][]byte
 
@@ -107,7 +162,7 @@ func (f Fields) Timestamp(key byte) (time.Time, error) {
 func (f Fields) String(key byte) (string, error) {
 	buf, ok := f[key]
 	if !ok {
-		return "", errors.New("not found")
+		return "", &FieldError{Code: key, Want: -1, Got: -1}
 	}
 	return string(buf), nil
 }
@@ -126,10 +181,10 @@ func (f Fields) Float32(key byte) (float32, error) {
 func (f Fields) get(key byte, expectedLen int) ([]byte, error) {
 	buf, ok := f[key]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, &FieldError{Code: key, Want: expectedLen, Got: -1}
 	}
 	if len(buf) != expectedLen {
-		return nil, fmt.Errorf("expected length %d, got %d", expectedLen, len(buf))
+		return nil, &FieldError{Code: key, Want: expectedLen, Got: len(buf)}
 	}
 	return buf, nil
 }
@@ -162,7 +217,13 @@ func NewFieldWriter() *FieldWriter {
 // FieldWriter generates CPTV encoded fields.
 type FieldWriter struct {
 	data       []byte
-	fieldCount uint8
+	fieldCount int
+
+	// long is set if a field is longer than 255 bytes, which requires
+	// CPTV version 3. Fields of exactly 255 bytes are stored as long
+	// fields but written inline for version 2 (see encode).
+	long      bool
+	hasLen255 bool
 }
 
 // Uint8 writes a uint8 field with key 'code' and value 'v'
@@ -200,19 +261,33 @@ func (f *FieldWriter) Timestamp(code byte, t time.Time) {
 	f.Uint64(code, uint64(t.UnixNano()/1000))
 }
 
-// String writes a character string field with key 'code' and value 'v'
+// String writes a character string field with key 'code' and value
+// 'v'. Strings longer than 255 bytes are written as long fields.
 func (f *FieldWriter) String(code byte, v string) error {
-	if len(v) > 255 {
-		return fmt.Errorf("string length %d greater than 255", len(v))
-	}
+	return f.Raw(code, []byte(v))
+}
 
-	byteSlice := []byte(v)
-	lenByteSlice := byte(len(byteSlice))
-	f.data = append(f.data, lenByteSlice)
-	f.data = append(f.data, code)
-	f.data = append(f.data, byteSlice...)
+// Raw writes a field with key 'code' containing the bytes in
+// 'v'. Fields longer than 255 bytes are written as long fields, as
+// are fields of exactly 255 bytes in CPTV version 3 files.
+func (f *FieldWriter) Raw(code byte, v []byte) error {
+	if len(v) > MaxFieldLen {
+		return fmt.Errorf("field length %d: %w", len(v), ErrFieldTooLong)
+	}
+	if len(v) < longFieldLen {
+		f.data = append(f.data, byte(len(v)), code)
+	} else {
+		b := []byte{longFieldLen, 0, 0, 0, 0, code}
+		binary.LittleEndian.PutUint32(b[1:], uint32(len(v)))
+		f.data = append(f.data, b...)
+		if len(v) > longFieldLen {
+			f.long = true
+		} else {
+			f.hasLen255 = true
+		}
+	}
+	f.data = append(f.data, v...)
 	f.fieldCount++
-
 	return nil
 }
 
@@ -224,7 +299,37 @@ func (f *FieldWriter) Float32(code byte, v float32) {
 	f.fieldCount++
 }
 
-// Bytes returns the encoded header and the number of fields represented.
+// Bytes returns the encoded header and the number of fields
+// represented. Fields of 255 bytes are written inline, as for CPTV
+// version 2, unless there are also longer fields.
 func (f *FieldWriter) Bytes() ([]byte, int) {
-	return f.data, int(f.fieldCount)
+	return f.encode(f.long), f.fieldCount
+}
+
+// encode returns the encoded fields. If long is false, fields of
+// exactly 255 bytes are written inline as in CPTV version 2, where a
+// Length of 255 doesn't introduce a long field.
+func (f *FieldWriter) encode(long bool) []byte {
+	if long || !f.hasLen255 {
+		return f.data
+	}
+	out := make([]byte, 0, len(f.data))
+	for i := 0; i < len(f.data); {
+		size := int(f.data[i])
+		if size != longFieldLen {
+			out = append(out, f.data[i:i+2+size]...)
+			i += 2 + size
+			continue
+		}
+		size = int(binary.LittleEndian.Uint32(f.data[i+1:]))
+		start := i + 6
+		if size == longFieldLen {
+			out = append(out, longFieldLen, f.data[i+5])
+		} else {
+			out = append(out, f.data[i:start]...)
+		}
+		out = append(out, f.data[start:start+size]...)
+		i = start + size
+	}
+	return out
 }
@@ -15,25 +15,22 @@
 package cptv
 
 import (
-	"bufio"
 	"os"
 )
 
 // NewFileReader returns a new FileReader from the filename.
-func NewFileReader(filename string) (*FileReader, error) {
+func NewFileReader(filename string, opts ...ReaderOption) (*FileReader, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	br := bufio.NewReader(f)
-	r, err := NewReader(br)
+	r, err := NewReader(f, opts...)
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
 	return &FileReader{
 		Reader: r,
-		br:     br,
 		f:      f,
 	}, nil
 }
@@ -42,8 +39,7 @@ func NewFileReader(filename string) (*FileReader, error) {
 // a CPTV stream from a disk file.
 type FileReader struct {
 	*Reader
-	br *bufio.Reader
-	f  *os.File
+	f *os.File
 }
 
 // Name returns the name of the file being read
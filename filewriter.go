@@ -23,14 +23,14 @@ import (
 
 // NewFileWriter creates file 'filename' and returns a new FileWriter
 // with underlying buffer (bufio) Writer
-func NewFileWriter(filename string, c cptvframe.CameraSpec) (*FileWriter, error) {
+func NewFileWriter(filename string, c cptvframe.CameraSpec, opts ...WriterOption) (*FileWriter, error) {
 	f, err := os.Create(filename)
 	if err != nil {
 		return nil, err
 	}
 	bw := bufio.NewWriter(f)
 	return &FileWriter{
-		Writer: NewWriter(bw, c),
+		Writer: NewWriter(bw, c, opts...),
 		bw:     bw,
 		f:      f,
 	}, nil
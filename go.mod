@@ -1,6 +1,6 @@
 module github.com/TheCacophonyProject/go-cptv
 
-go 1.12
+go 1.13
 
 require (
 	github.com/TheCacophonyProject/lepton3 v0.0.0-20200213011619-1934a9300bd3
@@ -0,0 +1,212 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// indexInterval is the number of frames between the decompressor
+// snapshots kept in a frame index.
+const indexInterval = 32
+
+// ErrNotSeekable is returned when an operation needs to return to an
+// earlier part of a recording but the underlying reader doesn't
+// implement io.Seeker.
+var ErrNotSeekable = errors.New("reader is not seekable")
+
+// frameIndex records where each frame of a recording starts in the
+// uncompressed CPTV stream. Frames are indexed in order as they are
+// read.
+type frameIndex struct {
+	entries  []indexEntry
+	complete bool
+}
+
+type indexEntry struct {
+	offset     int64
+	timeOn     time.Duration
+	background bool
+	keyframe   bool
+
+	// snapshot holds the decompressor's reference frame from before
+	// the frame was decoded. It is only kept every indexInterval
+	// frames and isn't required for keyframes.
+	snapshot *cptvframe.Frame
+}
+
+func (idx *frameIndex) add(e indexEntry) {
+	idx.entries = append(idx.entries, e)
+}
+
+// seekPoint returns the closest indexed frame at or before frame n
+// which can be decoded without reading any earlier frames. -1 is
+// returned if there is no such frame.
+func (idx *frameIndex) seekPoint(n int) int {
+	if n >= len(idx.entries) {
+		n = len(idx.entries) - 1
+	}
+	for ; n >= 0; n-- {
+		if e := idx.entries[n]; e.keyframe || e.snapshot != nil {
+			return n
+		}
+	}
+	return -1
+}
+
+// Position returns the number of the frame which will be returned by
+// the next call to ReadFrame. Frames are numbered from 0 and the
+// background frame, if present, is frame 0.
+func (r *Reader) Position() int {
+	if r.next != nil {
+		return r.parsed - 1
+	}
+	return r.parsed
+}
+
+// SeekFrame positions the Reader so that the next call to ReadFrame
+// returns frame n (see Position for how frames are numbered). io.EOF
+// is returned if the recording has fewer than n frames.
+//
+// Seeking starts indexing the recording. Indexed frames can be
+// returned to quickly without decoding the frames before them.
+// Seeking backwards requires the Reader to have been created from an
+// io.ReadSeeker, otherwise ErrNotSeekable is returned.
+func (r *Reader) SeekFrame(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid frame number %d", n)
+	}
+	if err := r.startIndex(); err != nil {
+		return err
+	}
+	return r.seekFrame(n)
+}
+
+// SeekTime positions the Reader at the first frame recorded at least
+// d after the first (non-background) frame in the recording. io.EOF
+// is returned if the recording is shorter than d.
+//
+// The whole recording is indexed the first time SeekTime is called.
+func (r *Reader) SeekTime(d time.Duration) error {
+	if err := r.buildIndex(); err != nil {
+		return err
+	}
+	first := -1
+	for i, e := range r.index.entries {
+		if e.background {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if e.timeOn-r.index.entries[first].timeOn >= d {
+			return r.seekFrame(i)
+		}
+	}
+	return io.EOF
+}
+
+// BuildIndex reads through the whole recording, indexing every frame
+// so that later calls to SeekFrame and SeekTime are fast. The
+// position of the Reader is unchanged.
+func (r *Reader) BuildIndex() error {
+	pos := r.Position()
+	if err := r.buildIndex(); err != nil {
+		return err
+	}
+	return r.seekFrame(pos)
+}
+
+func (r *Reader) buildIndex() error {
+	if err := r.startIndex(); err != nil {
+		return err
+	}
+	if r.index.complete {
+		return nil
+	}
+	if err := r.seekFrame(math.MaxInt32); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// startIndex starts indexing the recording. If frames have already
+// been read the Reader is rewound so that they can be indexed too.
+func (r *Reader) startIndex() error {
+	if r.index != nil {
+		return nil
+	}
+	if r.parsed > 0 {
+		if err := r.rewind(); err != nil {
+			return err
+		}
+	}
+	r.index = new(frameIndex)
+	return nil
+}
+
+// seekFrame moves to frame n, jumping to the nearest indexed frame
+// if that is quicker than reading forward from the current position.
+func (r *Reader) seekFrame(n int) error {
+	pos := r.Position()
+	if n == pos {
+		return nil
+	}
+	if k := r.index.seekPoint(n); k >= 0 && (n < pos || k > pos) {
+		if err := r.jump(k); err != nil {
+			return err
+		}
+	} else if n < pos {
+		return fmt.Errorf("frame %d is not indexed", n)
+	}
+
+	r.next = nil
+	frame := r.EmptyFrame()
+	for r.parsed < n {
+		if err := r.readFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jump moves the parser to the start of indexed frame k and restores
+// the decompressor state needed to decode it.
+func (r *Reader) jump(k int) error {
+	e := r.index.entries[k]
+	if e.offset < r.parser.offset() {
+		if err := r.rewind(); err != nil {
+			return err
+		}
+	}
+	if err := r.parser.skip(e.offset - r.parser.offset()); err != nil {
+		return err
+	}
+	if e.keyframe {
+		r.decomp.Reset()
+	} else {
+		r.decomp.Reference().Copy(e.snapshot)
+	}
+	r.parsed = k
+	r.next = nil
+	r.broken = false
+	return nil
+}
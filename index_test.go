@@ -0,0 +1,168 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeekFrame(t *testing.T) {
+	camera := new(TestCamera)
+	frames := writeTestRecording(t, camera, 100)
+
+	r, err := NewReader(bytes.NewReader(frames.bytes))
+	require.NoError(t, err)
+
+	frame := r.EmptyFrame()
+	for _, n := range []int{50, 10, 99, 0, 33, 32, 31, 64, 63, 1} {
+		require.NoError(t, r.SeekFrame(n), "seek to %d", n)
+		assert.Equal(t, n, r.Position())
+		require.NoError(t, r.ReadFrame(frame))
+		assert.Equal(t, frames.frames[n], frame, "frame %d", n)
+		assert.Equal(t, n+1, r.Position())
+	}
+
+	// Reading on from a seek continues with the following frames.
+	require.NoError(t, r.SeekFrame(40))
+	for n := 40; n < 100; n++ {
+		require.NoError(t, r.ReadFrame(frame))
+		assert.Equal(t, frames.frames[n], frame, "frame %d", n)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frame))
+
+	require.NoError(t, r.SeekFrame(100))
+	assert.Equal(t, io.EOF, r.ReadFrame(frame))
+	assert.Equal(t, io.EOF, r.SeekFrame(101))
+	require.NoError(t, r.SeekFrame(99))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[99], frame)
+}
+
+func TestSeekFrameAfterRead(t *testing.T) {
+	camera := new(TestCamera)
+	frames := writeTestRecording(t, camera, 40)
+
+	r, err := NewReader(bytes.NewReader(frames.bytes))
+	require.NoError(t, err)
+	frame := r.EmptyFrame()
+	for n := 0; n < 20; n++ {
+		require.NoError(t, r.ReadFrame(frame))
+	}
+
+	require.NoError(t, r.BuildIndex())
+	assert.Equal(t, 20, r.Position())
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[20], frame)
+
+	require.NoError(t, r.SeekFrame(5))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[5], frame)
+}
+
+func TestSeekTime(t *testing.T) {
+	camera := new(TestCamera)
+	frames := writeTestRecording(t, camera, 50)
+
+	r, err := NewReader(bytes.NewReader(frames.bytes))
+	require.NoError(t, err)
+
+	frame := r.EmptyFrame()
+	require.NoError(t, r.SeekTime(0))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[0], frame)
+
+	// Frames are 100ms apart.
+	require.NoError(t, r.SeekTime(2*time.Second))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[20], frame)
+
+	require.NoError(t, r.SeekTime(2050*time.Millisecond))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[21], frame)
+
+	assert.Equal(t, io.EOF, r.SeekTime(time.Minute))
+}
+
+func TestSeekNotSeekable(t *testing.T) {
+	camera := new(TestCamera)
+	frames := writeTestRecording(t, camera, 10)
+
+	r, err := NewReader(bytes.NewBuffer(frames.bytes))
+	require.NoError(t, err)
+
+	// Seeking forward is fine.
+	frame := r.EmptyFrame()
+	require.NoError(t, r.SeekFrame(5))
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, frames.frames[5], frame)
+
+	// Seeking backward isn't.
+	assert.Equal(t, ErrNotSeekable, r.SeekFrame(2))
+}
+
+func TestSeekV2File(t *testing.T) {
+	r, err := NewFileReader("v2.cptv")
+	require.NoError(t, err)
+	defer r.Close()
+
+	var frames []*cptvframe.Frame
+	frame := r.EmptyFrame()
+	for {
+		err := r.ReadFrame(frame)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		frames = append(frames, frame.CreateCopy())
+	}
+
+	for _, n := range []int{100, 3, 64, 118, 0} {
+		require.NoError(t, r.SeekFrame(n))
+		require.NoError(t, r.ReadFrame(frame))
+		assert.Equal(t, frames[n], frame, "frame %d", n)
+	}
+}
+
+type testRecording struct {
+	bytes  []byte
+	frames []*cptvframe.Frame
+}
+
+// writeTestRecording writes a recording of count frames which all
+// differ from each other, 100ms apart.
+func writeTestRecording(t *testing.T, camera cptvframe.CameraSpec, count int) testRecording {
+	var rec testRecording
+	frame := makeTestFrame(camera)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	for i := 0; i < count; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		frame.Pix[i%camera.ResY()][0] = uint16(i)
+		frame.Status.TimeOn = 10*time.Second + time.Duration(i)*100*time.Millisecond
+		require.NoError(t, w.WriteFrame(frame))
+		rec.frames = append(rec.frames, frame.CreateCopy())
+	}
+	require.NoError(t, w.Close())
+	rec.bytes = buf.Bytes()
+	return rec
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+func newMEDCompressor(c cptvframe.CameraSpec) *medCompressor {
+	elems := c.ResX() * c.ResY()
+	outBuf := new(bytes.Buffer)
+	outBuf.Grow(2 * elems)
+	return &medCompressor{
+		cols:       c.ResX(),
+		frameDelta: make([]int32, elems),
+		outBuf:     outBuf,
+		prevFrame:  cptvframe.NewFrame(c),
+	}
+}
+
+// medCompressor implements CompressionMED. The difference from the
+// previous frame is predicted for each pixel from its left, upper and
+// upper-left neighbours using the median edge detector from LOCO-I,
+// so that both horizontal and vertical correlation are used. The
+// prediction errors are Rice coded as for CompressionRice.
+type medCompressor struct {
+	cols       int
+	frameDelta []int32
+	outBuf     *bytes.Buffer
+	prevFrame  *cptvframe.Frame
+}
+
+// Next compresses the next frame. The returned bit width is always 0
+// as it isn't needed to decompress the frame.
+func (c *medCompressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
+	for y, row := range curr.Pix {
+		prevRow := c.prevFrame.Pix[y]
+		deltas := c.frameDelta[y*c.cols : (y+1)*c.cols]
+		for x, v := range row {
+			deltas[x] = int32(v) - int32(prevRow[x])
+		}
+		copy(prevRow, row)
+	}
+
+	c.outBuf.Reset()
+	bw := bitWriter{w: c.outBuf}
+	var rs riceStats
+	rs.reset()
+	for i, d := range c.frameDelta {
+		u := zigzag(d - medPredict(c.frameDelta, c.cols, i))
+		bw.writeRice(u, rs.k())
+		rs.update(u)
+	}
+	bw.flush()
+	return 0, c.outBuf.Bytes()
+}
+
+func (c *medCompressor) Reset() {
+	clearFrame(c.prevFrame)
+}
+
+func newMEDDecompressor(c cptvframe.CameraSpec) *medDecompressor {
+	return &medDecompressor{
+		cols:      c.ResX(),
+		deltas:    make([]int32, c.ResX()*c.ResY()),
+		prevFrame: cptvframe.NewFrame(c),
+	}
+}
+
+// medDecompressor implements CompressionMED.
+type medDecompressor struct {
+	cols      int
+	deltas    []int32
+	prevFrame *cptvframe.Frame
+}
+
+func (d *medDecompressor) Next(_ uint8, compressed ByteReaderReader, out *cptvframe.Frame) error {
+	br := bitReader{r: compressed}
+	var rs riceStats
+	rs.reset()
+	for i := range d.deltas {
+		u, err := br.readRice(rs.k())
+		if err != nil {
+			return err
+		}
+		rs.update(u)
+		d.deltas[i] = medPredict(d.deltas, d.cols, i) + unzigzag(u)
+	}
+
+	for y, row := range out.Pix {
+		prevRow := d.prevFrame.Pix[y]
+		deltas := d.deltas[y*d.cols : (y+1)*d.cols]
+		for x := range row {
+			row[x] = uint16(int32(prevRow[x]) + deltas[x])
+		}
+		copy(prevRow, row)
+	}
+	return nil
+}
+
+func (d *medDecompressor) Reset() {
+	clearFrame(d.prevFrame)
+}
+
+func (d *medDecompressor) Reference() *cptvframe.Frame {
+	return d.prevFrame
+}
+
+// medPredict predicts the value at index i of vals, a frame stored
+// row by row with cols columns, from the values before it. Values on
+// the first row are predicted from their left neighbour and values in
+// the first column from their upper neighbour. Otherwise the median
+// edge detector is used.
+func medPredict(vals []int32, cols, i int) int32 {
+	x := i % cols
+	switch {
+	case i == 0:
+		return 0
+	case i < cols:
+		return vals[i-1]
+	case x == 0:
+		return vals[i-cols]
+	}
+	a := vals[i-1]      // left
+	b := vals[i-cols]   // up
+	c := vals[i-cols-1] // up-left
+	switch {
+	case c >= max32(a, b):
+		return min32(a, b)
+	case c <= min32(a, b):
+		return max32(a, b)
+	}
+	return a + b - c
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -15,6 +15,8 @@
 package cptv
 
 import (
+	"bytes"
+	"hash"
 	"io"
 )
 
@@ -64,3 +66,26 @@ func (r *nReader) ReadN(n int) ([]byte, error) {
 	}
 	return buf, nil
 }
+
+// countingReader wraps an io.Reader, keeping track of the number of
+// bytes read. If hash is set it is updated with the bytes read and if
+// raw is set they are appended to it.
+type countingReader struct {
+	r    io.Reader
+	n    int64
+	hash hash.Hash
+	raw  *bytes.Buffer
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.hash != nil {
+		c.hash.Write(p[:n])
+	}
+	if c.raw != nil {
+		c.raw.Write(p[:n])
+	}
+	return n, err
+}
@@ -15,10 +15,12 @@
 package cptv
 
 import (
+	"bytes"
 	"compress/gzip"
-	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 )
 
 // NewParser returns a new Parser instance, for parsing a gzip
@@ -30,32 +32,119 @@ func NewParser(r io.Reader) (*Parser, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newParser(gr), nil
+}
+
+// newParser returns a new Parser for an uncompressed CPTV stream.
+func newParser(r io.Reader) *Parser {
 	return &Parser{
-		r: nReader{gr},
-	}, nil
+		r:        nReader{&countingReader{r: r}},
+		sections: make(map[byte]Fields),
+	}
 }
 
 // Parser is the low-level type for pulling apart the sections and
 // fields of a CPTV file. See Reader for a high-level interface.
 type Parser struct {
-	r       nReader
-	version int
+	r        nReader
+	version  int
+	sections map[byte]Fields
+
+	// signedDigest is the digest of the stream before the signature
+	// section, if hashing is enabled.
+	signedDigest []byte
+
+	// If keepRaw has been called, rawFrame holds the encoded section
+	// header and fields of the last frame returned by Frame and
+	// rawSections the other sections which preceded it.
+	raw         *bytes.Buffer
+	rawFrame    []byte
+	rawSections []rawSection
+}
+
+// rawSection is an encoded section consisting only of fields.
+type rawSection struct {
+	code byte
+	data []byte
+}
+
+// Section returns the fields of the section with the given code
+// which followed the frames of the CPTV file (for example, the
+// footer). nil is returned if the section hasn't been seen. Such
+// sections are only available once Frame has returned io.EOF.
+func (p *Parser) Section(code byte) Fields {
+	return p.sections[code]
+}
+
+// enableHash makes the parser hash the uncompressed CPTV stream. It
+// must be called before anything is parsed.
+func (p *Parser) enableHash(h hash.Hash) {
+	p.r.Reader.(*countingReader).hash = h
+}
+
+// digest returns the hash of the stream so far, or nil if hashing
+// isn't enabled.
+func (p *Parser) digest() []byte {
+	h := p.r.Reader.(*countingReader).hash
+	if h == nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// keepRaw makes the parser keep the encoded bytes of the sections it
+// parses (see Parser.rawFrame) so that they can be copied
+// unchanged. The frame data isn't kept.
+func (p *Parser) keepRaw() {
+	p.raw = new(bytes.Buffer)
+}
+
+// startRaw starts keeping the encoded bytes of a section.
+func (p *Parser) startRaw() {
+	if p.raw != nil {
+		p.raw.Reset()
+		p.r.Reader.(*countingReader).raw = p.raw
+	}
+}
+
+// stopRaw stops keeping encoded bytes and returns the bytes kept since
+// startRaw. The bytes are only valid until startRaw is called again.
+func (p *Parser) stopRaw() []byte {
+	if p.raw == nil {
+		return nil
+	}
+	p.r.Reader.(*countingReader).raw = nil
+	return p.raw.Bytes()
+}
+
+// offset returns the number of bytes of the uncompressed CPTV stream
+// consumed so far.
+func (p *Parser) offset() int64 {
+	return p.r.Reader.(*countingReader).n
+}
+
+// skip discards the next n bytes of the uncompressed CPTV stream.
+func (p *Parser) skip(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, p.r.Reader, n)
+	return err
 }
 
 // Header parses a CPTV file header from the open file.
 func (p *Parser) Header() (Fields, error) {
 	if magicRead, err := p.r.ReadN(4); err != nil {
 		return nil, err
+	} else if string(magicRead) == encryptedMagic {
+		return nil, ErrEncrypted
 	} else if string(magicRead) != magic {
-		return nil, errors.New("magic not found")
+		return nil, ErrBadMagic
 	}
 
 	versionByte, err := p.r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	if versionByte == 0 || versionByte > version {
-		return nil, errors.New("unsupported CPTV version")
+	if versionByte == 0 || versionByte > maxVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, versionByte)
 	}
 	p.version = int(versionByte)
 
@@ -63,19 +152,80 @@ func (p *Parser) Header() (Fields, error) {
 		return nil, err
 	}
 
-	return readFieldsN(p.r)
+	return readFieldsN(p.r, p.longFields())
+}
+
+// longFields returns true if fields may be longer than 255 bytes.
+func (p *Parser) longFields() bool {
+	return p.version >= int(longFieldsVersion)
+}
+
+// checkAfterSignature rejects a section which follows the signature
+// section. Only the footer, which isn't covered by the signature, may
+// follow it so that unsigned frames can't be appended to a signed
+// recording.
+func (p *Parser) checkAfterSignature(section byte) error {
+	if p.sections[SignatureSection] == nil {
+		return nil
+	}
+	if section == FooterSection && p.sections[FooterSection] == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: section %q follows the signature", ErrBadSignature, section)
 }
 
 // Frame parses a CPTV frame section header from the open file and returns
-// a subreader that allows access to the frame bytes.
+// a subreader that allows access to the frame bytes. io.EOF is
+// returned at the end of the file and ErrTruncatedFrame if the file
+// ends part way through a section.
+//
+// Sections other than frames which follow the frames in the file are
+// stored for retrieval with Section.
 func (p *Parser) Frame() (Fields, io.Reader, error) {
-	if err := p.checkByte("section", FrameSection); err != nil {
-		return nil, nil, err
+	fields, frameReader, err := p.frame()
+	if err == io.ErrUnexpectedEOF {
+		err = ErrTruncatedFrame
+	}
+	return fields, frameReader, err
+}
+
+func (p *Parser) frame() (Fields, io.Reader, error) {
+	p.rawSections = p.rawSections[:0]
+	for {
+		p.startRaw()
+		digest := p.digest()
+		section, err := p.r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := p.checkAfterSignature(section); err != nil {
+			return nil, nil, err
+		}
+		if section == FrameSection {
+			break
+		}
+		if section == HeaderSection {
+			return nil, nil, fmt.Errorf("unexpected section: %d", section)
+		}
+		// All other sections consist only of fields.
+		fields, err := readFieldsN(p.r, p.longFields())
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		p.sections[section] = fields
+		if section == SignatureSection {
+			p.signedDigest = digest
+		}
+		if raw := p.stopRaw(); raw != nil {
+			p.rawSections = append(p.rawSections, rawSection{section, append([]byte(nil), raw...)})
+		}
 	}
-	fields, err := readFieldsN(p.r)
+
+	fields, err := readFieldsN(p.r, p.longFields())
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, unexpectedEOF(err)
 	}
+	p.rawFrame = p.stopRaw()
 	frameSize, err := fields.Uint32(FrameSize)
 	if err != nil {
 		return nil, nil, err
@@ -90,6 +240,15 @@ func (p *Parser) Frame() (Fields, io.Reader, error) {
 	return fields, frameReader, nil
 }
 
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for use
+// when the end of the file isn't expected.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 // checkByte reads a byte from the file and checks it against an 'expected'
 // value. 'label' is used for the error report only
 func (p *Parser) checkByte(label string, expected byte) error {
@@ -0,0 +1,248 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// Summary describes the frames of a CPTV recording.
+type Summary struct {
+	// FrameCount is the total number of frames, including the
+	// background frame (if present).
+	FrameCount      int
+	BackgroundFrame bool
+
+	// FirstTimeOn and LastTimeOn are the camera's time on at the
+	// first and last (non-background) frames. They are zero for
+	// recordings which don't record time on (CPTV v1).
+	FirstTimeOn time.Duration
+	LastTimeOn  time.Duration
+
+	// Duration is the length of the recording, from the start of the
+	// first frame to the end of the last one.
+	Duration time.Duration
+}
+
+// Probe returns a Summary of the CPTV recording read from rs. The
+// recording must start at the beginning of rs. The position of rs is
+// restored before Probe returns so Probe may be used while the
+// recording is being read.
+//
+// If the recording was written with a footer (see WithFooter) the
+// summary is read directly from it, otherwise the frames of the
+// recording are scanned (but not decoded). The footer is trusted as
+// it is: it isn't covered by a checksum or the recording's signature
+// and isn't compared with the frames, so a damaged or modified
+// recording may be summarised incorrectly. Use Verify to check a
+// recording that may be untrustworthy.
+func Probe(rs io.ReadSeeker) (Summary, error) {
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer rs.Seek(pos, io.SeekStart)
+
+	if summary, ok := probeFooter(rs); ok {
+		return summary, nil
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return Summary{}, err
+	}
+	return scanSummary(rs)
+}
+
+// probeFooter attempts to read a footer from the end of rs.
+func probeFooter(rs io.ReadSeeker) (Summary, bool) {
+	size := int64(footerSize())
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil || end < size {
+		return Summary{}, false
+	}
+	if _, err := rs.Seek(end-size, io.SeekStart); err != nil {
+		return Summary{}, false
+	}
+	gr, err := gzip.NewReader(io.LimitReader(rs, size))
+	if err != nil {
+		return Summary{}, false
+	}
+	data, err := ioutil.ReadAll(gr)
+	if err != nil || len(data) == 0 || data[0] != FooterSection {
+		return Summary{}, false
+	}
+	fields, err := ReadFields(bytes.NewReader(data[1:]))
+	if err != nil {
+		return Summary{}, false
+	}
+	summary, err := summaryFromFooter(fields)
+	if err != nil {
+		return Summary{}, false
+	}
+	return summary, true
+}
+
+// scanSummary generates a Summary by parsing all the frames in r.
+func scanSummary(r io.Reader) (Summary, error) {
+	p, err := NewParser(bufio.NewReader(r))
+	if err != nil {
+		return Summary{}, err
+	}
+	header, err := p.Header()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var sb summaryBuilder
+	for {
+		fields, frameReader, err := p.Frame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Summary{}, err
+		}
+		if _, err := io.Copy(ioutil.Discard, frameReader); err != nil {
+			return Summary{}, err
+		}
+
+		var status cptvframe.Telemetry
+		if background, err := fields.Uint8(BackgroundFrame); err == nil {
+			status.BackgroundFrame = background != 0
+		}
+		timeOn, err := fields.Uint32(TimeOn)
+		timed := err == nil && p.version >= 2
+		if timed {
+			status.TimeOn = millisToDuration(timeOn)
+		}
+		sb.add(&status, timed)
+	}
+	return sb.finish(header.FPS()), nil
+}
+
+// summaryBuilder accumulates a Summary as frames are written or
+// read.
+type summaryBuilder struct {
+	s       Summary
+	frames  int
+	untimed bool
+}
+
+// add updates the summary with the next frame. timed should be false
+// if the frame's time on isn't known.
+func (sb *summaryBuilder) add(status *cptvframe.Telemetry, timed bool) {
+	sb.s.FrameCount++
+	if status.BackgroundFrame {
+		sb.s.BackgroundFrame = true
+		return
+	}
+	// Frame times are stored with millisecond resolution.
+	timeOn := status.TimeOn.Truncate(time.Millisecond)
+	if !timed {
+		sb.untimed = true
+	} else if sb.frames == 0 {
+		sb.s.FirstTimeOn = timeOn
+	}
+	sb.s.LastTimeOn = timeOn
+	sb.frames++
+}
+
+// finish returns the summary of the frames seen so far. fps is used
+// to determine the duration of each frame. The duration is truncated
+// to milliseconds, the resolution of the frame times.
+func (sb *summaryBuilder) finish(fps int) Summary {
+	s := sb.s
+	if sb.frames == 0 {
+		return s
+	}
+	var period time.Duration
+	if fps > 0 {
+		period = time.Second / time.Duration(fps)
+	}
+	if sb.untimed {
+		s.FirstTimeOn = 0
+		s.LastTimeOn = 0
+		s.Duration = time.Duration(sb.frames) * period
+	} else {
+		s.Duration = s.LastTimeOn - s.FirstTimeOn + period
+	}
+	if s.Duration < 0 {
+		// The frame times went backwards.
+		s.Duration = 0
+	}
+	s.Duration = s.Duration.Truncate(time.Millisecond)
+	return s
+}
+
+// footerFields returns the fields of a footer section for summary.
+// All fields are always written so that the footer has a fixed size.
+func footerFields(summary Summary) *FieldWriter {
+	fields := NewFieldWriter()
+	fields.Uint32(FooterFrames, uint32(summary.FrameCount))
+	var background uint8
+	if summary.BackgroundFrame {
+		background = 1
+	}
+	fields.Uint8(FooterBackground, background)
+	fields.Uint32(FooterFirstTimeOn, durationToMillis(summary.FirstTimeOn))
+	fields.Uint32(FooterLastTimeOn, durationToMillis(summary.LastTimeOn))
+	fields.Uint32(FooterDuration, durationToMillis(summary.Duration))
+	return fields
+}
+
+func summaryFromFooter(fields Fields) (Summary, error) {
+	var s Summary
+	frames, err := fields.Uint32(FooterFrames)
+	if err != nil {
+		return s, err
+	}
+	s.FrameCount = int(frames)
+	background, err := fields.Uint8(FooterBackground)
+	if err != nil {
+		return s, err
+	}
+	s.BackgroundFrame = background != 0
+	first, err := fields.Uint32(FooterFirstTimeOn)
+	if err != nil {
+		return s, err
+	}
+	s.FirstTimeOn = millisToDuration(first)
+	last, err := fields.Uint32(FooterLastTimeOn)
+	if err != nil {
+		return s, err
+	}
+	s.LastTimeOn = millisToDuration(last)
+	duration, err := fields.Uint32(FooterDuration)
+	if err != nil {
+		return s, err
+	}
+	s.Duration = millisToDuration(duration)
+	return s, nil
+}
+
+// footerSize returns the size of an encoded footer, including its
+// gzip framing.
+func footerSize() int {
+	footer, err := encodeFooter(footerFields(Summary{}))
+	if err != nil {
+		panic(err)
+	}
+	return len(footer)
+}
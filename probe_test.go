@@ -0,0 +1,129 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeFooter(t *testing.T) {
+	camera := new(TestCamera)
+	background := makeTestFrame(camera)
+	background.Status.BackgroundFrame = true
+	frame := makeTestFrame(camera)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, WithFooter())
+	require.NoError(t, w.WriteHeader(Header{FPS: 21, BackgroundFrame: background}))
+	for i := 0; i < 10; i++ {
+		frame.Status.TimeOn = 5*time.Second + time.Duration(i)*time.Second/21
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	expected := Summary{
+		FrameCount:      11,
+		BackgroundFrame: true,
+		FirstTimeOn:     5 * time.Second,
+		LastTimeOn:      5*time.Second + 428*time.Millisecond,
+		Duration:        475 * time.Millisecond,
+	}
+
+	// The footer is used.
+	rs := bytes.NewReader(buf.Bytes())
+	summary, err := Probe(rs)
+	require.NoError(t, err)
+	assert.Equal(t, expected, summary)
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pos)
+
+	// Scanning the frames gives the same result.
+	summary, err = scanSummary(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, expected, summary)
+
+	// The footer doesn't get in the way of reading the frames.
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	count, err := r.FrameCount()
+	require.NoError(t, err)
+	assert.Equal(t, 11, count)
+	assert.NotNil(t, r.parser.Section(FooterSection))
+}
+
+func TestProbeNoFooter(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTestRecording(t, camera, 30)
+
+	rs := bytes.NewReader(rec.bytes)
+	r, err := NewReader(rs)
+	require.NoError(t, err)
+	frame := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frame))
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+
+	// Probe part way through reading.
+	summary, err := Probe(rs)
+	require.NoError(t, err)
+	assert.Equal(t, 30, summary.FrameCount)
+	assert.False(t, summary.BackgroundFrame)
+	assert.Equal(t, 10*time.Second, summary.FirstTimeOn)
+	assert.Equal(t, 12900*time.Millisecond, summary.LastTimeOn)
+	assert.Equal(t, 3011*time.Millisecond, summary.Duration)
+
+	// The position of the underlying reader is unchanged so reading
+	// can continue.
+	pos2, err := rs.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, pos, pos2)
+	require.NoError(t, r.ReadFrame(frame))
+	assert.Equal(t, rec.frames[1], frame)
+}
+
+func TestSummaryTimesBackwards(t *testing.T) {
+	var sb summaryBuilder
+	sb.add(&cptvframe.Telemetry{TimeOn: 10 * time.Second}, true)
+	sb.add(&cptvframe.Telemetry{TimeOn: time.Second}, true)
+	assert.Equal(t, time.Duration(0), sb.finish(9).Duration)
+}
+
+func TestProbeFiles(t *testing.T) {
+	f, err := os.Open("v1.cptv")
+	require.NoError(t, err)
+	defer f.Close()
+	summary, err := Probe(f)
+	require.NoError(t, err)
+	assert.Equal(t, 100, summary.FrameCount)
+	assert.Equal(t, time.Duration(0), summary.FirstTimeOn)
+	assert.Equal(t, 11111*time.Millisecond, summary.Duration)
+
+	f2, err := os.Open("v2.cptv")
+	require.NoError(t, err)
+	defer f2.Close()
+	summary, err = Probe(f2)
+	require.NoError(t, err)
+	assert.Equal(t, 119, summary.FrameCount)
+	assert.True(t, summary.Duration > 0)
+}
@@ -15,7 +15,11 @@
 package cptv
 
 import (
-	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"time"
@@ -25,27 +29,111 @@ import (
 )
 
 // NewReader returns a new Reader from the io.Reader given.
-func NewReader(r io.Reader) (*Reader, error) {
-	parser, err := NewParser(bufio.NewReader(r))
-	if err != nil {
-		return nil, err
+//
+// If the io.Reader also implements io.Seeker the Reader is able to
+// seek backwards in the recording (see SeekFrame).
+func NewReader(r io.Reader, opts ...ReaderOption) (*Reader, error) {
+	reader := &Reader{src: r}
+	for _, opt := range opts {
+		opt(reader)
 	}
-	header, err := parser.Header()
-	if err != nil {
+	if s, ok := r.(io.Seeker); ok {
+		if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+			reader.start = start
+			reader.seekable = true
+		}
+	}
+	if err := reader.open(); err != nil {
 		return nil, err
 	}
-	return &Reader{
-		parser: parser,
-		decomp: NewDecompressor(header),
-		header: header,
-	}, nil
+	return reader, nil
 }
 
-// Reader uses a Parser and Decompressor to read CPTV recordings.
+// Reader uses a Parser and a FrameDecompressor to read CPTV
+// recordings.
 type Reader struct {
 	parser *Parser
-	decomp *Decompressor
+	decomp FrameDecompressor
 	header Fields
+
+	src      io.Reader
+	start    int64
+	seekable bool
+
+	// parsed is the number of frames read from the parser so far.
+	parsed int
+	index  *frameIndex
+
+	// background holds a copy of the background frame once it has
+	// been read. next holds a frame which was read ahead by Header
+	// and is still to be returned by ReadFrame.
+	background *cptvframe.Frame
+	next       *cptvframe.Frame
+
+	// broken is set when a frame couldn't be decoded. Following
+	// frames can't be decoded until the next keyframe.
+	broken bool
+
+	// aligned is set when the last frame read was consumed completely
+	// so that reading can continue even if it couldn't be decoded.
+	aligned bool
+
+	// frameFields holds the fields of the last frame read.
+	frameFields Fields
+
+	strict    bool
+	publicKey ed25519.PublicKey
+	keys      KeyProvider
+}
+
+// ReaderOption configures optional Reader behaviour. Options are
+// passed to NewReader.
+type ReaderOption func(*Reader)
+
+var errBrokenReference = errors.New("frame depends on a frame which couldn't be decoded")
+
+// open starts parsing the CPTV stream from the current position of
+// the source reader.
+func (r *Reader) open() error {
+	stream, err := r.openStream(r.src)
+	if err != nil {
+		return err
+	}
+	parser := newParser(stream)
+	if r.publicKey != nil {
+		parser.enableHash(sha256.New())
+	}
+	header, err := parser.Header()
+	if err != nil {
+		return err
+	}
+	if r.strict {
+		if err := validateHeader(header); err != nil {
+			return err
+		}
+	}
+	codec, err := lookupCodec(compressionScheme(header))
+	if err != nil {
+		return err
+	}
+	r.parser = parser
+	r.decomp = codec.NewDecompressor(header)
+	r.header = header
+	r.parsed = 0
+	r.next = nil
+	r.broken = false
+	return nil
+}
+
+// rewind returns the Reader to the first frame of the recording.
+func (r *Reader) rewind() error {
+	if !r.seekable {
+		return ErrNotSeekable
+	}
+	if _, err := r.src.(io.Seeker).Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	return r.open()
 }
 
 // EmptyFrame returns an initialized cptvframe.Frame sized
@@ -184,21 +272,157 @@ func (r *Reader) Accuracy() float32 {
 	return pre
 }
 
+// HeaderFields returns the raw fields of the recording's header,
+// including fields which the Reader has no method for.
+func (r *Reader) HeaderFields() Fields {
+	return copyFieldMap(r.header)
+}
+
+// FrameFields returns the raw fields of the frame most recently
+// returned by ReadFrame, including fields describing how the frame is
+// stored such as BitWidth and FrameSize. nil is returned if no frame
+// has been read.
+func (r *Reader) FrameFields() Fields {
+	return copyFieldMap(r.frameFields)
+}
+
+func copyFieldMap(f Fields) Fields {
+	if f == nil {
+		return nil
+	}
+	out := make(Fields, len(f))
+	for code, data := range f {
+		out[code] = data
+	}
+	return out
+}
+
 func (r *Reader) HasBackgroundFrame() bool {
 	back, _ := r.header.Uint8(BackgroundFrame)
 	return back != 0
 }
 
+// Header returns the header of the CPTV recording in the form
+// accepted by Writer.WriteHeader. The Present field of the returned
+// Header records which fields were found in the recording so that
+// absent fields can be told apart from zero values.
+//
+// If the recording has a background frame it is returned in
+// Header.BackgroundFrame. Calling Header before the background frame
+// has been read doesn't change what subsequent ReadFrame calls return.
+func (r *Reader) Header() (Header, error) {
+	h := headerFromFields(r.header)
+	if r.HasBackgroundFrame() {
+		if r.background == nil && r.next == nil {
+			frame := r.EmptyFrame()
+			if err := r.readFrame(frame); err != nil {
+				return Header{}, err
+			}
+			r.next = frame
+		}
+		if r.background != nil {
+			h.BackgroundFrame = r.background.CreateCopy()
+			h.Present |= HasBackgroundFrame
+		}
+	}
+	return h, nil
+}
+
 // ReadFrame extracts and decompresses the next frame in a CPTV
 // recording. At the end of the recording an io.EOF error will be
 // returned.
+//
+// If a frame can't be decoded, or its data doesn't match its checksum
+// (ErrFrameChecksum), ReadFrame may be called again to continue with
+// the following frame. Frames which depend on a frame
+// which couldn't be decoded will also fail until the next keyframe
+// (see WithKeyframeInterval).
 func (r *Reader) ReadFrame(out *cptvframe.Frame) error {
+	if r.next != nil {
+		out.Copy(r.next)
+		r.next = nil
+		return nil
+	}
+	return r.readFrame(out)
+}
+
+func (r *Reader) readFrame(out *cptvframe.Frame) error {
+	r.aligned = false
+	offset := r.parser.offset()
 	fields, frameReader, err := r.parser.Frame()
+	r.frameFields = fields
 	if err != nil {
+		if err == io.EOF && r.index != nil && r.parsed == len(r.index.entries) {
+			r.index.complete = true
+		}
+		if err == io.EOF && r.publicKey != nil {
+			if sigErr := r.checkSignature(); sigErr != nil {
+				return sigErr
+			}
+		}
+		return err
+	}
+	keyframe, _ := fields.Uint8(Keyframe)
+	if r.index != nil && r.parsed == len(r.index.entries) {
+		r.index.add(r.indexEntry(offset, fields, keyframe != 0))
+	}
+	r.parsed++
+
+	// Check the frame data against its checksum as it is read.
+	var data io.Reader = frameReader
+	checksum := crc32.NewIEEE()
+	expectedCRC, crcErr := fields.Uint32(FrameCRC)
+	if crcErr == nil {
+		data = io.TeeReader(frameReader, checksum)
+	}
+
+	if r.strict {
+		err = validateFrame(fields, r.parser.version, r.header)
+		if err != nil {
+			r.broken = true
+		}
+	}
+	if err == nil {
+		err = r.decodeFrame(fields, keyframe != 0, data, out)
+	}
+	// Skip any frame data which wasn't used by the decompressor so
+	// that the parser is positioned at the start of the next frame.
+	// Only running out of data means the frame is truncated; other
+	// errors (e.g. from decryption) are returned as they are.
+	_, copyErr := io.Copy(ioutil.Discard, data)
+	if copyErr == io.ErrUnexpectedEOF || copyErr == nil && frameReader.(*io.LimitedReader).N > 0 {
+		return ErrTruncatedFrame
+	}
+	if copyErr != nil {
+		if err == nil {
+			err = copyErr
+		}
 		return err
 	}
+	r.aligned = true
+	if crcErr == nil && checksum.Sum32() != expectedCRC {
+		// The frame may have been decoded without error but can't
+		// be trusted, and neither can frames which depend on it.
+		r.broken = true
+		if out.Status.BackgroundFrame {
+			r.background = nil
+		}
+		return fmt.Errorf("%w (expected %08x, got %08x)", ErrFrameChecksum, expectedCRC, checksum.Sum32())
+	}
+	return err
+}
+
+func (r *Reader) decodeFrame(fields Fields, keyframe bool, frameReader io.Reader, out *cptvframe.Frame) error {
+	if keyframe {
+		r.decomp.Reset()
+		r.broken = false
+	} else if r.broken {
+		return errBrokenReference
+	}
+
 	bitWidth, err := fields.Uint8(BitWidth)
 	if err != nil {
+		r.broken = true
 		return err
 	}
 
@@ -219,6 +443,19 @@ func (r *Reader) ReadFrame(out *cptvframe.Frame) error {
 			out.Status.LastFFCTempC = float64(lastFFC)
 		}
 
+		frameCount, err := fields.Uint32(FrameCount)
+		if err == nil {
+			out.Status.FrameCount = int(frameCount)
+		}
+
+		frameMean, err := fields.Uint16(FrameMean)
+		if err == nil {
+			out.Status.FrameMean = frameMean
+		}
+
+		// Only written when set.
+		out.Status.FFCState, _ = fields.String(FFCState)
+
 		backgroundFrame, err := fields.Uint8(BackgroundFrame)
 		if err == nil {
 			out.Status.BackgroundFrame = backgroundFrame != 0
@@ -227,18 +464,56 @@ func (r *Reader) ReadFrame(out *cptvframe.Frame) error {
 		}
 	}
 
+	out.Extra, out.Reserved = extraFields(fields, frameFieldCodes)
+
 	lastFFCTime, err := fields.Uint32(LastFFCTime)
 	if err == nil {
 		out.Status.LastFFCTime = millisToDuration(lastFFCTime)
 	}
 
-	return r.decomp.Next(bitWidth, &nReader{frameReader}, out)
+	if err := r.decomp.Next(bitWidth, &nReader{frameReader}, out); err != nil {
+		r.broken = true
+		return err
+	}
+	if out.Status.BackgroundFrame && r.background == nil {
+		r.background = out.CreateCopy()
+	}
+	return nil
+}
+
+// indexEntry returns the index entry for the frame about to be
+// decoded.
+func (r *Reader) indexEntry(offset int64, fields Fields, keyframe bool) indexEntry {
+	e := indexEntry{
+		offset:   offset,
+		keyframe: keyframe,
+	}
+	if r.parser.version >= 2 {
+		timeOn, _ := fields.Uint32(TimeOn)
+		e.timeOn = millisToDuration(timeOn)
+		background, _ := fields.Uint8(BackgroundFrame)
+		e.background = background != 0
+	} else {
+		e.timeOn = time.Duration(r.parsed) * time.Second / time.Duration(r.FPS())
+	}
+	ref := r.decomp.Reference()
+	if ref == nil {
+		// Every frame can be decompressed independently.
+		e.keyframe = true
+	} else if !keyframe && !r.broken && r.parsed%indexInterval == 0 {
+		e.snapshot = ref.CreateCopy()
+	}
+	return e
 }
 
 // FrameCount returns the remaining number of frames in a CPTV file.
 // After this call, all remaining frames will have been consumed.
 func (r *Reader) FrameCount() (int, error) {
 	count := 0
+	if r.next != nil {
+		r.next = nil
+		count++
+	}
 	for {
 		_, fr, err := r.parser.Frame()
 		if err != nil {
@@ -248,11 +523,80 @@ func (r *Reader) FrameCount() (int, error) {
 			return count, err
 		}
 		io.Copy(ioutil.Discard, fr)
+		r.parsed++
 		count++
 	}
 	return count, nil
 }
 
+// headerFromFields converts the fields of a CPTV header section to a
+// Header. The background frame isn't set.
+func headerFromFields(f Fields) Header {
+	var h Header
+	if ts, err := f.Timestamp(Timestamp); err == nil {
+		h.Timestamp = ts
+		h.Present |= HasTimestamp
+	}
+	if name, err := f.String(DeviceName); err == nil {
+		h.DeviceName = name
+		h.Present |= HasDeviceName
+	}
+	if id, err := f.Uint32(DeviceID); err == nil {
+		h.DeviceID = int(id)
+		h.Present |= HasDeviceID
+	}
+	if serial, err := f.Uint32(CameraSerial); err == nil {
+		h.CameraSerial = int(serial)
+		h.Present |= HasCameraSerial
+	}
+	if firmware, err := f.String(Firmware); err == nil {
+		h.Firmware = firmware
+		h.Present |= HasFirmware
+	}
+	if secs, err := f.Uint8(PreviewSecs); err == nil {
+		h.PreviewSecs = int(secs)
+		h.Present |= HasPreviewSecs
+	}
+	if conf, err := f.String(MotionConfig); err == nil {
+		h.MotionConfig = conf
+		h.Present |= HasMotionConfig
+	}
+	if lat, err := f.Float32(Latitude); err == nil {
+		h.Latitude = lat
+		h.Present |= HasLatitude
+	}
+	if long, err := f.Float32(Longitude); err == nil {
+		h.Longitude = long
+		h.Present |= HasLongitude
+	}
+	if ts, err := f.Timestamp(LocTimestamp); err == nil {
+		h.LocTimestamp = ts
+		h.Present |= HasLocTimestamp
+	}
+	if alt, err := f.Float32(Altitude); err == nil {
+		h.Altitude = alt
+		h.Present |= HasAltitude
+	}
+	if acc, err := f.Float32(Accuracy); err == nil {
+		h.Accuracy = acc
+		h.Present |= HasAccuracy
+	}
+	if fps, err := f.Uint8(FPS); err == nil {
+		h.FPS = int(fps)
+		h.Present |= HasFPS
+	}
+	if brand, err := f.String(Brand); err == nil {
+		h.Brand = brand
+		h.Present |= HasBrand
+	}
+	if model, err := f.String(Model); err == nil {
+		h.Model = model
+		h.Present |= HasModel
+	}
+	h.Extra, h.Reserved = extraFields(f, headerFieldCodes)
+	return h
+}
+
 func millisToDuration(ms uint32) time.Duration {
 	return time.Duration(ms) * time.Millisecond
 }
@@ -0,0 +1,222 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RedactAction identifies how a header field is redacted.
+type RedactAction int
+
+// Redaction actions. Not all actions apply to every field: RedactRound
+// only applies to the latitude, longitude and altitude, RedactHash only
+// applies to the device name, device ID and camera serial, and only
+// RedactKeep and RedactDrop apply to the other fields.
+const (
+	// RedactKeep leaves the field unchanged.
+	RedactKeep RedactAction = iota
+	// RedactDrop removes the field.
+	RedactDrop
+	// RedactRound rounds the field to Redaction.Places decimal
+	// places.
+	RedactRound
+	// RedactHash replaces the field with a salted hash of its value,
+	// so that recordings from the same device can still be grouped
+	// without revealing the device.
+	RedactHash
+)
+
+// Redaction describes how a header field is redacted.
+type Redaction struct {
+	Action RedactAction
+	// Places is the number of decimal places kept by RedactRound,
+	// from 0 to MaxRedactPlaces.
+	Places int
+}
+
+// MaxRedactPlaces is the most decimal places RedactRound can keep.
+const MaxRedactPlaces = 10
+
+// RedactionPolicy describes how each of the identifying fields of a
+// CPTV header is redacted.
+//
+// Fields which this package doesn't understand may also identify a
+// device or site, so by default they are dropped: the header's Extra
+// and Reserved fields and, when a recording is redacted, the same
+// fields of each frame and any unknown sections following the
+// frames. Set KeepUnknownFields to keep them. Otherwise the zero value
+// keeps all fields.
+type RedactionPolicy struct {
+	Latitude     Redaction
+	Longitude    Redaction
+	Altitude     Redaction
+	LocTimestamp Redaction
+	DeviceName   Redaction
+	DeviceID     Redaction
+	CameraSerial Redaction
+	MotionConfig Redaction
+
+	// KeepUnknownFields keeps fields and sections which aren't
+	// understood by this package.
+	KeepUnknownFields bool
+
+	// Salt is used by RedactHash. A secret salt prevents hashed values
+	// from being recovered by hashing likely values.
+	Salt []byte
+}
+
+// Apply redacts a header according to the policy. An error is
+// returned if the policy uses an action for a field it doesn't apply
+// to, in which case the header is unchanged.
+func (p *RedactionPolicy) Apply(h *Header) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	p.apply(h)
+	return nil
+}
+
+func (p *RedactionPolicy) validate() error {
+	floats := []struct {
+		name string
+		r    Redaction
+	}{
+		{"latitude", p.Latitude},
+		{"longitude", p.Longitude},
+		{"altitude", p.Altitude},
+	}
+	for _, f := range floats {
+		if f.r.Action == RedactHash {
+			return fmt.Errorf("%s can't be hashed", f.name)
+		}
+		if f.r.Action == RedactRound && (f.r.Places < 0 || f.r.Places > MaxRedactPlaces) {
+			return fmt.Errorf("invalid number of decimal places for %s: %d", f.name, f.r.Places)
+		}
+	}
+	others := []struct {
+		name string
+		r    Redaction
+	}{
+		{"location timestamp", p.LocTimestamp},
+		{"motion config", p.MotionConfig},
+	}
+	for _, other := range others {
+		if other.r.Action != RedactKeep && other.r.Action != RedactDrop {
+			return fmt.Errorf("%s can only be kept or dropped", other.name)
+		}
+	}
+	ids := []struct {
+		name string
+		r    Redaction
+	}{
+		{"device name", p.DeviceName},
+		{"device ID", p.DeviceID},
+		{"camera serial", p.CameraSerial},
+	}
+	for _, id := range ids {
+		if id.r.Action == RedactRound {
+			return fmt.Errorf("%s can't be rounded", id.name)
+		}
+	}
+	return nil
+}
+
+func (p *RedactionPolicy) apply(h *Header) {
+	h.Latitude = p.redactFloat(p.Latitude, h.Latitude, &h.Present, HasLatitude)
+	h.Longitude = p.redactFloat(p.Longitude, h.Longitude, &h.Present, HasLongitude)
+	h.Altitude = p.redactFloat(p.Altitude, h.Altitude, &h.Present, HasAltitude)
+	if p.LocTimestamp.Action == RedactDrop {
+		h.LocTimestamp = time.Time{}
+		h.Present &^= HasLocTimestamp
+	}
+	if p.MotionConfig.Action == RedactDrop {
+		h.MotionConfig = ""
+		h.Present &^= HasMotionConfig
+	}
+	if !p.KeepUnknownFields {
+		h.Extra = nil
+		h.Reserved = nil
+	}
+
+	switch p.DeviceName.Action {
+	case RedactDrop:
+		h.DeviceName = ""
+		h.Present &^= HasDeviceName
+	case RedactHash:
+		if h.Present.Has(HasDeviceName) || h.DeviceName != "" {
+			h.DeviceName = hex.EncodeToString(p.hash(h.DeviceName)[:8])
+		}
+	}
+	h.DeviceID = p.redactID(p.DeviceID, h.DeviceID, &h.Present, HasDeviceID)
+	h.CameraSerial = p.redactID(p.CameraSerial, h.CameraSerial, &h.Present, HasCameraSerial)
+}
+
+func (p *RedactionPolicy) redactFloat(r Redaction, v float32, present *HeaderMask, flag HeaderMask) float32 {
+	switch r.Action {
+	case RedactDrop:
+		*present &^= flag
+		return 0
+	case RedactRound:
+		scale := math.Pow(10, float64(r.Places))
+		return float32(math.Round(float64(v)*scale) / scale)
+	}
+	return v
+}
+
+func (p *RedactionPolicy) redactID(r Redaction, v int, present *HeaderMask, flag HeaderMask) int {
+	switch r.Action {
+	case RedactDrop:
+		*present &^= flag
+		return 0
+	case RedactHash:
+		if v == 0 && !present.Has(flag) {
+			return 0
+		}
+		// IDs are stored as uint32 values. Keep them positive so that
+		// they are also valid on 32 bit platforms.
+		return int(binary.BigEndian.Uint32(p.hash(strconv.Itoa(v))) & math.MaxInt32)
+	}
+	return v
+}
+
+func (p *RedactionPolicy) hash(v string) []byte {
+	mac := hmac.New(sha256.New, p.Salt)
+	mac.Write([]byte(v))
+	return mac.Sum(nil)
+}
+
+// Redact copies the CPTV recording in 'in' to 'out', redacting its
+// header according to policy. The frames are copied without being
+// decoded (see RewriteHeader), apart from dropping unknown frame fields
+// unless policy.KeepUnknownFields is set.
+func Redact(in io.Reader, out io.Writer, policy RedactionPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	var keepField func(code byte) bool
+	if !policy.KeepUnknownFields {
+		keepField = IsFrameField
+	}
+	return rewrite(in, out, policy.apply, keepField)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactionPolicy(t *testing.T) {
+	policy := RedactionPolicy{
+		Latitude:     Redaction{Action: RedactRound, Places: 2},
+		Longitude:    Redaction{Action: RedactRound, Places: 1},
+		Altitude:     Redaction{Action: RedactDrop},
+		DeviceName:   Redaction{Action: RedactHash},
+		DeviceID:     Redaction{Action: RedactHash},
+		CameraSerial: Redaction{Action: RedactDrop},
+		Salt:         []byte("salt"),
+	}
+	h := Header{
+		Latitude:     -43.53214,
+		Longitude:    172.63682,
+		Altitude:     21.5,
+		DeviceName:   "nz42",
+		DeviceID:     1234,
+		CameraSerial: 5678,
+		Firmware:     "3.3.1",
+		Present:      HasAltitude | HasCameraSerial,
+	}
+	require.NoError(t, policy.Apply(&h))
+	assert.Equal(t, float32(-43.53), h.Latitude)
+	assert.Equal(t, float32(172.6), h.Longitude)
+	assert.Equal(t, float32(0), h.Altitude)
+	assert.Equal(t, 0, h.CameraSerial)
+	assert.False(t, h.Present.Has(HasAltitude))
+	assert.False(t, h.Present.Has(HasCameraSerial))
+	assert.Len(t, h.DeviceName, 16)
+	assert.NotEqual(t, "nz42", h.DeviceName)
+	assert.NotEqual(t, 1234, h.DeviceID)
+	assert.Equal(t, "3.3.1", h.Firmware)
+
+	// Hashes are repeatable but depend on the salt.
+	h2 := Header{DeviceName: "nz42", DeviceID: 1234}
+	require.NoError(t, policy.Apply(&h2))
+	assert.Equal(t, h.DeviceName, h2.DeviceName)
+	assert.Equal(t, h.DeviceID, h2.DeviceID)
+	policy.Salt = []byte("pepper")
+	h3 := Header{DeviceName: "nz42", DeviceID: 1234}
+	require.NoError(t, policy.Apply(&h3))
+	assert.NotEqual(t, h.DeviceName, h3.DeviceName)
+	assert.NotEqual(t, h.DeviceID, h3.DeviceID)
+
+	// Absent fields stay absent.
+	h4 := Header{}
+	require.NoError(t, policy.Apply(&h4))
+	assert.Equal(t, Header{}, h4)
+}
+
+func TestRedactionPolicyInvalid(t *testing.T) {
+	h := Header{DeviceName: "nz42", Latitude: -43.5}
+	policy := RedactionPolicy{Latitude: Redaction{Action: RedactHash}}
+	assert.EqualError(t, policy.Apply(&h), "latitude can't be hashed")
+	policy = RedactionPolicy{DeviceName: Redaction{Action: RedactRound}}
+	assert.EqualError(t, policy.Apply(&h), "device name can't be rounded")
+	policy = RedactionPolicy{Latitude: Redaction{Action: RedactRound, Places: MaxRedactPlaces + 1}}
+	assert.EqualError(t, policy.Apply(&h), "invalid number of decimal places for latitude: 11")
+	policy = RedactionPolicy{MotionConfig: Redaction{Action: RedactHash}}
+	assert.EqualError(t, policy.Apply(&h), "motion config can only be kept or dropped")
+	assert.Equal(t, Header{DeviceName: "nz42", Latitude: -43.5}, h)
+}
+
+func TestRedact(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTrimRecording(t, camera, Header{
+		DeviceName: "nz42",
+		Latitude:   -43.53214,
+		Longitude:  172.63682,
+	}, true)
+
+	out := new(bytes.Buffer)
+	policy := RedactionPolicy{
+		Latitude:   Redaction{Action: RedactDrop},
+		Longitude:  Redaction{Action: RedactDrop},
+		DeviceName: Redaction{Action: RedactDrop},
+	}
+	require.NoError(t, Redact(bytes.NewReader(rec.bytes), out, policy))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, "", header.DeviceName)
+	assert.False(t, header.Present.Has(HasDeviceName))
+	assert.False(t, header.Present.Has(HasLatitude))
+	assert.False(t, header.Present.Has(HasLongitude))
+	assertFrames(t, r, rec.frames)
+}
+
+func TestRedactUnknownFields(t *testing.T) {
+	camera := new(TestCamera)
+	var frames []*cptvframe.Frame
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera)
+	require.NoError(t, w.WriteHeader(Header{
+		DeviceName:   "nz42",
+		LocTimestamp: time.Date(2020, 5, 1, 3, 0, 0, 0, time.UTC),
+		MotionConfig: "site: 7",
+		Extra:        map[byte][]byte{0x80: []byte("site-7")},
+		Reserved:     map[byte][]byte{0x70: []byte("gps")},
+	}))
+	frame := makeTestFrame(camera)
+	for i := 0; i < 5; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		frame.Extra = map[byte][]byte{0x81: []byte("-43.53,172.63")}
+		frame.Reserved = map[byte][]byte{0x70: []byte("gps")}
+		require.NoError(t, w.WriteFrame(frame))
+		frames = append(frames, frame.CreateCopy())
+	}
+	require.NoError(t, w.Close())
+
+	// Unknown fields are dropped by default.
+	out := new(bytes.Buffer)
+	policy := RedactionPolicy{
+		LocTimestamp: Redaction{Action: RedactDrop},
+		MotionConfig: Redaction{Action: RedactDrop},
+	}
+	require.NoError(t, Redact(bytes.NewReader(buf.Bytes()), out, policy))
+	assert.False(t, bytes.Contains(gunzipBytes(t, out.Bytes()), []byte("site")))
+	assert.False(t, bytes.Contains(gunzipBytes(t, out.Bytes()), []byte("gps")))
+	assert.False(t, bytes.Contains(gunzipBytes(t, out.Bytes()), []byte("-43.53")))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, "nz42", header.DeviceName)
+	assert.False(t, header.Present.Has(HasLocTimestamp|HasMotionConfig))
+	assert.Nil(t, header.Extra)
+	assert.Nil(t, header.Reserved)
+	for _, frame := range frames {
+		frame.Extra = nil
+		frame.Reserved = nil
+	}
+	assertFrames(t, r, frames)
+
+	// They can be kept.
+	out.Reset()
+	policy = RedactionPolicy{KeepUnknownFields: true}
+	require.NoError(t, Redact(bytes.NewReader(buf.Bytes()), out, policy))
+	r, err = NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	header, err = r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("site-7"), header.Extra[0x80])
+	assert.Equal(t, []byte("gps"), header.Reserved[0x70])
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, []byte("-43.53,172.63"), frameD.Extra[0x81])
+	assert.Equal(t, []byte("gps"), frameD.Reserved[0x70])
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// RewriteHeader copies the CPTV recording in 'in' to 'out', changing
+// its header with edit. The frames and any other sections are copied
+// byte for byte without being decoded, so this is much faster than
+// reading and writing the recording again, and the frames are
+// unchanged.
+//
+// The Header passed to edit doesn't include the background frame and
+// any changes to Header.BackgroundFrame are ignored. The recording's
+// version is kept, so header fields longer than 255 bytes can only be
+// written to CPTV version 3 recordings. As the recording is changed,
+// any signature (see WithSigningKey) is removed. The footer is kept.
+func RewriteHeader(in io.Reader, out io.Writer, edit func(*Header)) error {
+	return rewrite(in, out, edit, nil)
+}
+
+// rewrite implements RewriteHeader. If keepField is set, frame fields
+// for which it returns false are dropped, as are any unknown sections.
+func rewrite(in io.Reader, out io.Writer, edit func(*Header), keepField func(code byte) bool) error {
+	parser, err := NewParser(bufio.NewReader(in))
+	if err != nil {
+		return err
+	}
+	fields, err := parser.Header()
+	if err != nil {
+		return err
+	}
+	header := headerFromFields(fields)
+	edit(&header)
+	background, _ := fields.Uint8(BackgroundFrame)
+	newFields, err := headerFields(header, fields.ResX(), fields.ResY(), compressionScheme(fields), background != 0)
+	if err != nil {
+		return err
+	}
+	if newFields.long && parser.version < int(longFieldsVersion) {
+		return fmt.Errorf("long header fields can't be added to CPTV version %d recordings", parser.version)
+	}
+
+	bldr := NewBuilder(out)
+	bldr.version = byte(parser.version)
+	if err := bldr.WriteHeader(newFields); err != nil {
+		return err
+	}
+
+	parser.keepRaw()
+	w := rawWriter{bldr}
+	var footer []byte
+	for {
+		_, frameReader, frameErr := parser.Frame()
+		if frameErr != nil && frameErr != io.EOF {
+			return frameErr
+		}
+		// The signature no longer matches so is dropped. The footer
+		// is written separately once the stream is closed. Unknown
+		// sections are dropped along with unknown frame fields.
+		for _, section := range parser.rawSections {
+			switch section.code {
+			case SignatureSection:
+			case FooterSection:
+				footer = section.data
+			default:
+				if keepField == nil {
+					if _, err := w.Write(section.data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if frameErr == io.EOF {
+			break
+		}
+
+		rawFrame := parser.rawFrame
+		if keepField != nil {
+			rawFrame = filterFields(rawFrame, parser.longFields(), keepField)
+		}
+		if _, err := w.Write(rawFrame); err != nil {
+			return err
+		}
+		n := frameReader.(*io.LimitedReader).N
+		_, err := io.CopyN(w, frameReader, n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedFrame
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := bldr.Close(); err != nil {
+		return err
+	}
+	if footer != nil {
+		return bldr.WriteFooter(&FieldWriter{data: footer[2:], fieldCount: int(footer[1])})
+	}
+	return nil
+}
+
+// rawWriter writes encoded sections and frame data to a Builder's
+// stream unchanged.
+type rawWriter struct {
+	b *Builder
+}
+
+// Write implements io.Writer
+func (w rawWriter) Write(p []byte) (int, error) {
+	return w.b.write(p)
+}
+
+// filterFields returns the encoded section in raw without the fields
+// for which keep returns false. The other fields are unchanged. raw
+// must have been checked by the parser.
+func filterFields(raw []byte, long bool, keep func(code byte) bool) []byte {
+	out := []byte{raw[0], 0}
+	for i := 2; i < len(raw); {
+		start := i
+		size := int(raw[i])
+		i++
+		if long && size == longFieldLen {
+			size = int(binary.LittleEndian.Uint32(raw[i:]))
+			i += 4
+		}
+		code := raw[i]
+		i += 1 + size
+		if keep(code) {
+			out = append(out, raw[start:i]...)
+			out[1]++
+		}
+	}
+	return out
+}
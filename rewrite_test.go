@@ -0,0 +1,157 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteHeader(t *testing.T) {
+	data, err := ioutil.ReadFile("v2.cptv")
+	require.NoError(t, err)
+	frames, _ := loadV2Frames(t)
+
+	out := new(bytes.Buffer)
+	err = RewriteHeader(bytes.NewReader(data), out, func(h *Header) {
+		h.DeviceName = "fixed"
+		h.Latitude = -43.5
+		h.Longitude = 172.6
+	})
+	require.NoError(t, err)
+
+	orig, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	origHeader, err := orig.Header()
+	require.NoError(t, err)
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Version())
+	assert.Equal(t, "fixed", header.DeviceName)
+	assert.Equal(t, float32(-43.5), header.Latitude)
+	assert.Equal(t, float32(172.6), header.Longitude)
+	assert.Equal(t, origHeader.Timestamp, header.Timestamp)
+	assert.Equal(t, origHeader.DeviceID, header.DeviceID)
+	assert.Equal(t, origHeader.BackgroundFrame, header.BackgroundFrame)
+	assertFrames(t, r, frames)
+}
+
+func TestRewriteHeaderFooter(t *testing.T) {
+	camera := new(TestCamera)
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	frames, data := writeSignedRecording(t, camera, priv)
+
+	out := new(bytes.Buffer)
+	err = RewriteHeader(bytes.NewReader(data), out, func(h *Header) {
+		h.DeviceName = "nz43"
+	})
+	require.NoError(t, err)
+
+	summary, err := Probe(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, len(frames), summary.FrameCount)
+	assert.Equal(t, ErrUnsigned, VerifySignature(bytes.NewReader(out.Bytes()), priv.Public().(ed25519.PublicKey)))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "nz43", r.DeviceName())
+	assertFrames(t, r, frames)
+}
+
+func TestRewriteHeaderUnchanged(t *testing.T) {
+	camera := new(TestCamera)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, WithFooter(), WithFrameChecksums())
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42"}))
+	frame := makeTestFrame(camera)
+	for i := 0; i < 5; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	// The sections are copied byte for byte, keeping the order of
+	// their fields.
+	out := new(bytes.Buffer)
+	require.NoError(t, RewriteHeader(bytes.NewReader(buf.Bytes()), out, func(*Header) {}))
+	assert.Equal(t, gunzipBytes(t, buf.Bytes()), gunzipBytes(t, out.Bytes()))
+}
+
+func TestRewriteHeaderLongFields(t *testing.T) {
+	camera := new(TestCamera)
+	config := strings.Repeat("motion: true\n", 30)
+
+	// The version of the recording isn't changed.
+	rec := writeTestRecording(t, camera, 5)
+	err := RewriteHeader(bytes.NewReader(rec.bytes), ioutil.Discard, func(h *Header) {
+		h.MotionConfig = config
+	})
+	assert.EqualError(t, err, "long header fields can't be added to CPTV version 2 recordings")
+
+	var frames []*cptvframe.Frame
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, WithLongFields())
+	require.NoError(t, w.WriteHeader(Header{}))
+	frame := makeTestFrame(camera)
+	for i := 0; i < 5; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		require.NoError(t, w.WriteFrame(frame))
+		frames = append(frames, frame)
+	}
+	require.NoError(t, w.Close())
+
+	out := new(bytes.Buffer)
+	err = RewriteHeader(bytes.NewReader(buf.Bytes()), out, func(h *Header) {
+		h.MotionConfig = config
+	})
+	require.NoError(t, err)
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Version())
+	assert.Equal(t, config, r.MotionConfig())
+	assertFrames(t, r, frames)
+}
+
+func TestRewriteHeaderV1(t *testing.T) {
+	f, err := os.Open("v1.cptv")
+	require.NoError(t, err)
+	defer f.Close()
+
+	out := new(bytes.Buffer)
+	err = RewriteHeader(f, out, func(h *Header) {
+		h.DeviceName = "v1"
+	})
+	require.NoError(t, err)
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.Version())
+	assert.Equal(t, "v1", r.DeviceName())
+	count, err := r.FrameCount()
+	require.NoError(t, err)
+	assert.True(t, count > 0)
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+const (
+	// riceEscape is the longest unary prefix written for a Rice
+	// coded value. Values which would need a longer prefix are
+	// written as riceEscape 1 bits followed by the full 32 bit value.
+	riceEscape = 24
+
+	// riceMaxK is the largest Rice parameter used.
+	riceMaxK = 24
+
+	// riceInitSum and riceHalveAt control how the Rice parameter
+	// adapts: the sum of recent values starts at riceInitSum and the
+	// statistics are halved after riceHalveAt values so that the
+	// parameter follows local changes in the data.
+	riceInitSum = 16
+	riceHalveAt = 64
+)
+
+func newRiceCompressor(c cptvframe.CameraSpec) *riceCompressor {
+	elems := c.ResX() * c.ResY()
+	outBuf := new(bytes.Buffer)
+	outBuf.Grow(2 * elems)
+	return &riceCompressor{
+		frameDelta: make([]int32, elems),
+		outBuf:     outBuf,
+		prevFrame:  cptvframe.NewFrame(c),
+	}
+}
+
+// riceCompressor implements CompressionRice. The "delta of deltas"
+// generated in the same way as for CompressionDelta are entropy coded
+// using adaptive Rice coding, so that a few large deltas don't
+// increase the size of every other delta in the frame.
+type riceCompressor struct {
+	frameDelta []int32
+	outBuf     *bytes.Buffer
+	prevFrame  *cptvframe.Frame
+}
+
+// Next compresses the next frame. The returned bit width is always 0
+// as it isn't needed to decompress the frame.
+func (c *riceCompressor) Next(curr *cptvframe.Frame) (uint8, []byte) {
+	snakeDeltas(curr, c.prevFrame, c.frameDelta)
+
+	c.outBuf.Reset()
+	binary.Write(c.outBuf, binary.LittleEndian, c.frameDelta[0])
+	bw := bitWriter{w: c.outBuf}
+	var rs riceStats
+	rs.reset()
+	for i := 1; i < len(c.frameDelta); i++ {
+		u := zigzag(c.frameDelta[i] - c.frameDelta[i-1])
+		bw.writeRice(u, rs.k())
+		rs.update(u)
+	}
+	bw.flush()
+	return 0, c.outBuf.Bytes()
+}
+
+func (c *riceCompressor) Reset() {
+	clearFrame(c.prevFrame)
+}
+
+func newRiceDecompressor(c cptvframe.CameraSpec) *riceDecompressor {
+	return &riceDecompressor{
+		deltas:    make([]int32, c.ResX()*c.ResY()),
+		prevFrame: cptvframe.NewFrame(c),
+	}
+}
+
+// riceDecompressor implements CompressionRice.
+type riceDecompressor struct {
+	deltas    []int32
+	prevFrame *cptvframe.Frame
+}
+
+func (d *riceDecompressor) Next(_ uint8, compressed ByteReaderReader, out *cptvframe.Frame) error {
+	var v int32
+	if err := binary.Read(compressed, binary.LittleEndian, &v); err != nil {
+		return err
+	}
+	br := bitReader{r: compressed}
+	var rs riceStats
+	rs.reset()
+	d.deltas[0] = v
+	for i := 1; i < len(d.deltas); i++ {
+		u, err := br.readRice(rs.k())
+		if err != nil {
+			return err
+		}
+		rs.update(u)
+		v += unzigzag(u)
+		d.deltas[i] = v
+	}
+	unsnakeDeltas(d.deltas, d.prevFrame, out)
+	return nil
+}
+
+func (d *riceDecompressor) Reset() {
+	clearFrame(d.prevFrame)
+}
+
+func (d *riceDecompressor) Reference() *cptvframe.Frame {
+	return d.prevFrame
+}
+
+// riceStats tracks recently coded values to choose the Rice
+// parameter for the next value (as in LOCO-I).
+type riceStats struct {
+	sum, count uint32
+}
+
+func (s *riceStats) reset() {
+	s.sum = riceInitSum
+	s.count = 1
+}
+
+// k returns the Rice parameter for the next value: the smallest k
+// such that count*2^k is at least the sum of the recent values.
+func (s *riceStats) k() uint {
+	var k uint
+	for s.count<<k < s.sum && k < riceMaxK {
+		k++
+	}
+	return k
+}
+
+func (s *riceStats) update(u uint32) {
+	s.sum += u
+	s.count++
+	if s.count == riceHalveAt {
+		s.sum >>= 1
+		s.count >>= 1
+	}
+}
+
+// zigzag maps signed integers to unsigned integers so that values
+// with small magnitudes have small codes (0, -1, 1, -2 -> 0, 1, 2, 3).
+func zigzag(v int32) uint32 {
+	return uint32(v<<1) ^ uint32(v>>31)
+}
+
+func unzigzag(u uint32) int32 {
+	return int32(u>>1) ^ -int32(u&1)
+}
+
+// bitWriter writes a stream of bits, most significant bit first.
+type bitWriter struct {
+	w     io.ByteWriter
+	bits  uint64
+	nbits uint
+}
+
+// writeBits writes the low n bits of v (n <= 32).
+func (b *bitWriter) writeBits(v uint32, n uint) {
+	b.bits = b.bits<<n | uint64(v)&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.w.WriteByte(byte(b.bits >> b.nbits))
+	}
+}
+
+// writeOnes writes n 1 bits.
+func (b *bitWriter) writeOnes(n uint) {
+	for ; n > 32; n -= 32 {
+		b.writeBits(0xffffffff, 32)
+	}
+	b.writeBits(0xffffffff, n)
+}
+
+// writeRice writes u as a Rice code with parameter k.
+func (b *bitWriter) writeRice(u uint32, k uint) {
+	q := u >> k
+	if q >= riceEscape {
+		b.writeOnes(riceEscape)
+		b.writeBits(u, 32)
+		return
+	}
+	b.writeOnes(uint(q))
+	b.writeBits(0, 1)
+	b.writeBits(u, k)
+}
+
+// flush writes out any remaining bits, padding the last byte with
+// zeroes.
+func (b *bitWriter) flush() {
+	if b.nbits > 0 {
+		b.w.WriteByte(byte(b.bits << (8 - b.nbits)))
+	}
+	b.bits = 0
+	b.nbits = 0
+}
+
+// bitReader reads a stream of bits written by bitWriter.
+type bitReader struct {
+	r     io.ByteReader
+	bits  uint64
+	nbits uint
+}
+
+// readBits reads n bits (n <= 32).
+func (b *bitReader) readBits(n uint) (uint32, error) {
+	for b.nbits < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		b.bits = b.bits<<8 | uint64(c)
+		b.nbits += 8
+	}
+	b.nbits -= n
+	return uint32(b.bits >> b.nbits & (1<<n - 1)), nil
+}
+
+// readRice reads a Rice code with parameter k.
+func (b *bitReader) readRice(k uint) (uint32, error) {
+	var q uint32
+	for {
+		bit, err := b.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+		if q == riceEscape {
+			return b.readBits(32)
+		}
+	}
+	low, err := b.readBits(k)
+	if err != nil {
+		return 0, err
+	}
+	return q<<k | low, nil
+}
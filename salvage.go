@@ -0,0 +1,94 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"io"
+)
+
+// SalvageResult describes the frames recovered by Salvage.
+type SalvageResult struct {
+	// Frames is the number of frames written, including the
+	// background frame.
+	Frames int
+	// Skipped is the number of frames which couldn't be decoded.
+	Skipped int
+	// Err is the error which stopped reading, or nil if the end of
+	// the recording was reached.
+	Err error
+}
+
+// Salvage reads as many frames as possible from a damaged CPTV
+// recording, such as one which was cut short by a power failure, and
+// writes them to a new recording in w with the original header and
+// compression scheme.
+//
+// Reading stops at the first problem which makes the rest of the
+// recording unreadable (for example the stream ending part way
+// through a frame or a missing gzip trailer). This is reported in
+// SalvageResult.Err. Frames which can't be decoded but don't stop
+// reading, including a damaged background frame, are skipped. An
+// error is only returned if the header can't be read or the new
+// recording can't be written.
+func Salvage(r io.Reader, w io.Writer) (SalvageResult, error) {
+	var result SalvageResult
+	reader, err := NewReader(r)
+	if err != nil {
+		return result, err
+	}
+
+	header, err := reader.Header()
+	if err != nil {
+		// The background frame is damaged; keep the rest of the
+		// header and drop it.
+		header = headerFromFields(reader.header)
+		if reader.aligned {
+			result.Skipped++
+		} else {
+			result.Err = err
+		}
+	}
+	writer := NewWriter(w, reader, copyOptions(reader)...)
+	if err := writer.WriteHeader(header); err != nil {
+		return result, err
+	}
+	if header.BackgroundFrame != nil {
+		result.Frames++
+	}
+
+	frame := reader.EmptyFrame()
+	for result.Err == nil {
+		err := reader.ReadFrame(frame)
+		switch {
+		case err == io.EOF:
+			return result, writer.Close()
+		case err != nil && reader.aligned:
+			// Only this frame was affected.
+			result.Skipped++
+			continue
+		case err != nil:
+			result.Err = err
+			continue
+		}
+		if frame.Status.BackgroundFrame && header.BackgroundFrame != nil {
+			continue
+		}
+		if err := writer.WriteFrame(frame); err != nil {
+			return result, err
+		}
+		result.Frames++
+	}
+	return result, writer.Close()
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalvageTruncated(t *testing.T) {
+	camera := new(TestCamera)
+	background := makeTestFrame(camera)
+	frames := make([]*cptvframe.Frame, 20)
+	frame := background
+	for i := range frames {
+		frame = makeOffsetFrame(camera, frame)
+		frame.Status.TimeOn = time.Duration(i) * time.Second
+		frames[i] = frame
+	}
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera, WithCompression(CompressionRice))
+	require.NoError(t, w.WriteHeader(Header{
+		DeviceName:      "nz42",
+		BackgroundFrame: background.CreateCopy(),
+	}))
+	for _, frame := range frames {
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+	data := cptvBytes.Bytes()
+
+	// The power was lost part way through writing.
+	out := new(bytes.Buffer)
+	result, err := Salvage(bytes.NewReader(data[:len(data)-100]), out)
+	require.NoError(t, err)
+	assert.True(t, errors.Is(result.Err, ErrTruncatedFrame))
+	assert.Equal(t, 0, result.Skipped)
+	require.True(t, result.Frames > 2, "%+v", result)
+	require.True(t, result.Frames < len(frames))
+
+	r, err := NewReader(out)
+	require.NoError(t, err)
+	assert.Equal(t, "nz42", r.DeviceName())
+	assert.Equal(t, CompressionRice, compressionScheme(r.header))
+	header, err := r.Header()
+	require.NoError(t, err)
+	background.Status.BackgroundFrame = true
+	assert.Equal(t, background, header.BackgroundFrame)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.True(t, frameD.Status.BackgroundFrame)
+	for _, frame := range frames[:result.Frames-1] {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestSalvageMissingTrailer(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTestRecording(t, camera, 10)
+
+	// The gzip trailer (CRC and size) is missing.
+	out := new(bytes.Buffer)
+	result, err := Salvage(bytes.NewReader(rec.bytes[:len(rec.bytes)-8]), out)
+	require.NoError(t, err)
+	assert.Equal(t, 10, result.Frames)
+	assert.Error(t, result.Err)
+
+	r, err := NewReader(out)
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	for _, frame := range rec.frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestSalvageCorruptFrame(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTestRecording(t, camera, 10)
+
+	// Frame 4 is corrupt, which breaks the frames after it.
+	fields := NewFieldWriter()
+	fields.Uint32(TimeOn, 1)
+	fields.Uint8(BitWidth, 8)
+	fields.Uint32(FrameSize, 2)
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	for i, frame := range rec.frames {
+		if i == 4 {
+			require.NoError(t, w.bldr.WriteFrame(fields, []byte{1, 2}))
+			continue
+		}
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	out := new(bytes.Buffer)
+	result, err := Salvage(bytes.NewReader(cptvBytes.Bytes()), out)
+	require.NoError(t, err)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, 4, result.Frames)
+	assert.Equal(t, 6, result.Skipped)
+}
+
+func TestSalvageCorruptBackground(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTestRecording(t, camera, 10)
+
+	// The background frame is corrupt. The first frame is a keyframe
+	// so the rest of the frames can still be decoded.
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera, WithKeyframeInterval(len(rec.frames)))
+	header, err := headerFields(Header{}, camera.ResX(), camera.ResY(), w.scheme, true)
+	require.NoError(t, err)
+	require.NoError(t, w.bldr.WriteHeader(header))
+	fields := NewFieldWriter()
+	fields.Uint8(BackgroundFrame, 1)
+	fields.Uint8(BitWidth, 8)
+	fields.Uint32(FrameSize, 2)
+	require.NoError(t, w.bldr.WriteFrame(fields, []byte{1, 2}))
+	for _, frame := range rec.frames {
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	out := new(bytes.Buffer)
+	result, err := Salvage(bytes.NewReader(cptvBytes.Bytes()), out)
+	require.NoError(t, err)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, len(rec.frames), result.Frames)
+
+	r, err := NewReader(out)
+	require.NoError(t, err)
+	assert.False(t, r.HasBackgroundFrame())
+	frameD := r.EmptyFrame()
+	for _, frame := range rec.frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestSalvageBadHeader(t *testing.T) {
+	_, err := Salvage(bytes.NewReader([]byte("not a cptv file")), new(bytes.Buffer))
+	assert.Error(t, err)
+}
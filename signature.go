@@ -0,0 +1,123 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	// ErrUnsigned is returned when a signature is required but the
+	// recording isn't signed.
+	ErrUnsigned = errors.New("recording is not signed")
+
+	// ErrBadSignature is returned when a recording's signature isn't
+	// valid for the expected public key, meaning that the recording
+	// was modified or was signed with a different key.
+	ErrBadSignature = errors.New("invalid signature")
+)
+
+// WithSigningKey makes the Writer sign the recording with an Ed25519
+// private key. The signature covers a SHA-256 hash of the whole
+// uncompressed CPTV stream (the header and all frames) and is written
+// in a signature section when the Writer is closed. The footer (see
+// WithFooter) isn't covered by the signature.
+func WithSigningKey(key ed25519.PrivateKey) WriterOption {
+	return func(w *Writer) {
+		w.signingKey = key
+		w.bldr.hash = sha256.New()
+	}
+}
+
+// writeSignature writes the signature section.
+func (w *Writer) writeSignature() error {
+	digest := w.bldr.hash.Sum(nil)
+	fields := NewFieldWriter()
+	if err := fields.Raw(Signature, ed25519.Sign(w.signingKey, digest)); err != nil {
+		return err
+	}
+	pub := w.signingKey.Public().(ed25519.PublicKey)
+	if err := fields.Raw(SignaturePublicKey, pub); err != nil {
+		return err
+	}
+	return w.bldr.WriteSection(SignatureSection, fields)
+}
+
+// WithPublicKey makes the Reader check that the recording was signed
+// with the private key matching key (see WithSigningKey). The
+// signature is checked once all the frames have been read: instead of
+// io.EOF, ReadFrame returns ErrUnsigned or ErrBadSignature if the
+// signature is missing or invalid.
+func WithPublicKey(key ed25519.PublicKey) ReaderOption {
+	return func(r *Reader) {
+		r.publicKey = key
+	}
+}
+
+// checkSignature checks the signature section once the end of the
+// recording has been reached.
+func (r *Reader) checkSignature() error {
+	fields := r.parser.Section(SignatureSection)
+	if fields == nil {
+		return ErrUnsigned
+	}
+	sig, err := fields.get(Signature, ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(r.publicKey, r.parser.signedDigest, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// VerifySignature reads all of a CPTV recording, checking that it was
+// signed with the private key matching key and hasn't been modified
+// since. ErrUnsigned or ErrBadSignature are returned if it wasn't.
+func VerifySignature(r io.Reader, key ed25519.PublicKey) error {
+	reader, err := NewReader(r, WithPublicKey(key))
+	if err != nil {
+		return err
+	}
+	for {
+		_, frameReader, err := reader.parser.Frame()
+		if err == io.EOF {
+			return reader.checkSignature()
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, frameReader); err != nil {
+			return err
+		}
+	}
+}
+
+// SignerPublicKey returns the public key recorded with the signature
+// of a recording, or nil if the recording isn't signed. It
+// is only available once all the frames have been read. Note that the
+// recorded key only identifies the signer; the signature must be
+// checked against a trusted key (see WithPublicKey).
+func (r *Reader) SignerPublicKey() ed25519.PublicKey {
+	key, err := r.parser.Section(SignatureSection).get(SignaturePublicKey, ed25519.PublicKeySize)
+	if err != nil {
+		return nil
+	}
+	return ed25519.PublicKey(key)
+}
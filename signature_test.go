@@ -0,0 +1,157 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	camera := new(TestCamera)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	frames, data := writeSignedRecording(t, camera, priv)
+
+	assert.NoError(t, VerifySignature(bytes.NewReader(data), pub))
+
+	// Frames can be read while checking the signature.
+	r, err := NewReader(bytes.NewReader(data), WithPublicKey(pub))
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	for _, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+	assert.Equal(t, pub, r.SignerPublicKey())
+
+	// The footer is still available.
+	summary, err := Probe(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, len(frames), summary.FrameCount)
+
+	// The signature is ignored by default.
+	r, err = NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	count, err := r.FrameCount()
+	require.NoError(t, err)
+	assert.Equal(t, len(frames), count)
+}
+
+func TestSignatureWrongKey(t *testing.T) {
+	camera := new(TestCamera)
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, data := writeSignedRecording(t, camera, priv)
+
+	assert.Equal(t, ErrBadSignature, VerifySignature(bytes.NewReader(data), otherPub))
+}
+
+func TestSignatureModified(t *testing.T) {
+	camera := new(TestCamera)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, data := writeSignedRecording(t, camera, priv)
+
+	// Change the device name in the header.
+	raw := gunzipBytes(t, data)
+	i := bytes.Index(raw, []byte("nz42"))
+	require.True(t, i > 0)
+	raw[i] = 'N'
+	modified := gzipBytes(t, raw)
+	assert.Equal(t, ErrBadSignature, VerifySignature(bytes.NewReader(modified), pub))
+
+	r, err := NewReader(bytes.NewReader(modified), WithPublicKey(pub))
+	require.NoError(t, err)
+	_, err = r.FrameCount()
+	require.NoError(t, err)
+	assert.Equal(t, ErrBadSignature, r.ReadFrame(r.EmptyFrame()))
+}
+
+func TestSignatureAppendedFrame(t *testing.T) {
+	camera := new(TestCamera)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	frames, data := writeSignedRecording(t, camera, priv)
+
+	// Take the encoded frame section from an unsigned recording by
+	// removing its header.
+	header := new(bytes.Buffer)
+	w := NewWriter(header, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	require.NoError(t, w.Close())
+	rec := writeTestRecording(t, camera, 1)
+	frameSection := gunzipBytes(t, rec.bytes)[len(gunzipBytes(t, header.Bytes())):]
+	require.Equal(t, byte(FrameSection), frameSection[0])
+
+	// Insert it between the signature and the footer.
+	raw := gunzipBytes(t, data)
+	i := bytes.LastIndex(raw, []byte{SignatureSection, 2, ed25519.SignatureSize, Signature})
+	require.True(t, i > 0)
+	i += 4 + ed25519.SignatureSize + 2 + ed25519.PublicKeySize
+	modified := append(append(append([]byte{}, raw[:i]...), frameSection...), raw[i:]...)
+	data = gzipBytes(t, modified)
+
+	err = VerifySignature(bytes.NewReader(data), pub)
+	assert.True(t, errors.Is(err, ErrBadSignature), err)
+
+	r, err := NewReader(bytes.NewReader(data), WithPublicKey(pub))
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	for range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+	}
+	err = r.ReadFrame(frameD)
+	assert.True(t, errors.Is(err, ErrBadSignature), err)
+}
+
+func TestUnsigned(t *testing.T) {
+	camera := new(TestCamera)
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	rec := writeTestRecording(t, camera, 3)
+
+	assert.Equal(t, ErrUnsigned, VerifySignature(bytes.NewReader(rec.bytes), pub))
+	r, err := NewReader(bytes.NewReader(rec.bytes))
+	require.NoError(t, err)
+	_, err = r.FrameCount()
+	require.NoError(t, err)
+	assert.Nil(t, r.SignerPublicKey())
+}
+
+func writeSignedRecording(t *testing.T, camera cptvframe.CameraSpec, key ed25519.PrivateKey) ([]*cptvframe.Frame, []byte) {
+	var frames []*cptvframe.Frame
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, WithSigningKey(key), WithFooter())
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42"}))
+	frame := makeTestFrame(camera)
+	for i := 0; i < 5; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		require.NoError(t, w.WriteFrame(frame))
+		frames = append(frames, frame)
+	}
+	require.NoError(t, w.Close())
+	return frames, buf.Bytes()
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import "fmt"
+
+// Strict makes the Reader validate the header and each frame before
+// decoding, returning an error for anything which doesn't follow the
+// CPTV specification instead of reading what it can:
+//
+//   - The compulsory header and frame fields must be present.
+//   - All fields understood by this package must have the right
+//     length for their type (see FieldError).
+//   - The resolution must be non-zero and at most MaxFramePixels
+//     pixels, so that damaged or malicious headers can't make the
+//     Reader allocate huge frames (see ErrBadResolution).
+//   - Frame bit widths and sizes must be valid for the resolution and
+//     compression scheme (see ErrCorruptFrame).
+func Strict() ReaderOption {
+	return func(r *Reader) {
+		r.strict = true
+	}
+}
+
+// MaxFramePixels is the largest frame, in pixels, which a strict
+// Reader accepts. It allows for cameras with a much higher resolution
+// than current thermal cameras.
+const MaxFramePixels = 2048 * 2048
+
+// Field lengths for the fixed size fields understood by this
+// package. Other fields may have any length.
+var (
+	headerFieldLens = map[byte]int{
+		Timestamp:       8,
+		XResolution:     4,
+		YResolution:     4,
+		Compression:     1,
+		DeviceID:        4,
+		CameraSerial:    4,
+		PreviewSecs:     1,
+		Latitude:        4,
+		Longitude:       4,
+		LocTimestamp:    8,
+		Altitude:        4,
+		Accuracy:        4,
+		FPS:             1,
+		BackgroundFrame: 1,
+	}
+	frameFieldLens = map[byte]int{
+		TimeOn:          4,
+		BitWidth:        1,
+		FrameSize:       4,
+		LastFFCTime:     4,
+		TempC:           4,
+		LastFFCTempC:    4,
+		BackgroundFrame: 1,
+		Keyframe:        1,
+		FrameCount:      4,
+		FrameMean:       2,
+		FrameCRC:        4,
+	}
+)
+
+func validateHeader(f Fields) error {
+	if err := checkFields(f, headerFieldLens, Timestamp, XResolution, YResolution, Compression); err != nil {
+		return err
+	}
+	resX, resY := f.ResX(), f.ResY()
+	if resX == 0 || resY == 0 || uint64(resX)*uint64(resY) > MaxFramePixels {
+		return fmt.Errorf("%w %dx%d", ErrBadResolution, resX, resY)
+	}
+	return nil
+}
+
+func validateFrame(f Fields, version int, header Fields) error {
+	required := []byte{BitWidth, FrameSize}
+	if background, _ := f.Uint8(BackgroundFrame); version >= 2 && background == 0 {
+		required = append(required, TimeOn, LastFFCTime, TempC, LastFFCTempC)
+	}
+	if err := checkFields(f, frameFieldLens, required...); err != nil {
+		return err
+	}
+
+	bitWidth, _ := f.Uint8(BitWidth)
+	frameSize, _ := f.Uint32(FrameSize)
+	pixels := header.ResX() * header.ResY()
+	var expected int
+	switch compressionScheme(header) {
+	case CompressionRaw:
+		if bitWidth != 8 && bitWidth != 16 {
+			return fmt.Errorf("%w: bit width %d", ErrCorruptFrame, bitWidth)
+		}
+		expected = pixels * int(bitWidth) / 8
+	case CompressionDelta:
+		if bitWidth == 0 || bitWidth > 32 {
+			return fmt.Errorf("%w: bit width %d", ErrCorruptFrame, bitWidth)
+		}
+		expected = 4 + ((pixels-1)*int(bitWidth)+7)/8
+	case CompressionRice, CompressionBlockDelta, CompressionMED:
+		if bitWidth != 0 {
+			return fmt.Errorf("%w: bit width %d", ErrCorruptFrame, bitWidth)
+		}
+		return nil
+	default:
+		// Nothing is known about the frames of other schemes.
+		return nil
+	}
+	if int(frameSize) != expected {
+		return fmt.Errorf("%w: frame size %d, expected %d", ErrCorruptFrame, frameSize, expected)
+	}
+	return nil
+}
+
+// checkFields checks that the fields in required are present and
+// that all fields in lens have the given length.
+func checkFields(f Fields, lens map[byte]int, required ...byte) error {
+	for _, code := range required {
+		if _, ok := f[code]; !ok {
+			return &FieldError{Code: code, Want: lens[code], Got: -1}
+		}
+	}
+	for code, data := range f {
+		if want, ok := lens[code]; ok && len(data) != want {
+			return &FieldError{Code: code, Want: want, Got: len(data)}
+		}
+	}
+	return nil
+}
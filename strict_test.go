@@ -0,0 +1,213 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadMagic(t *testing.T) {
+	_, err := NewReader(bytes.NewReader(gzipBytes(t, []byte("VTPC\x02H\x00"))))
+	assert.True(t, errors.Is(err, ErrBadMagic))
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	_, err := NewReader(bytes.NewReader(gzipBytes(t, []byte("CPTV\x09H\x00"))))
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+	assert.EqualError(t, err, "unsupported CPTV version 9")
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTestRecording(t, camera, 3)
+	data := gunzipBytes(t, rec.bytes)
+
+	// Find the start of the last frame.
+	r, err := NewReader(bytes.NewReader(rec.bytes))
+	require.NoError(t, err)
+	frame := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frame))
+	require.NoError(t, r.ReadFrame(frame))
+	lastFrame := int(r.parser.offset())
+
+	// Truncate the uncompressed stream in the frame data, in the
+	// frame fields and in the compressed stream.
+	for _, cptvBytes := range [][]byte{
+		gzipBytes(t, data[:len(data)-10]),
+		gzipBytes(t, data[:lastFrame+5]),
+		rec.bytes[:len(rec.bytes)-100],
+	} {
+		r, err := NewReader(bytes.NewReader(cptvBytes))
+		require.NoError(t, err)
+		require.NoError(t, r.ReadFrame(frame))
+		require.NoError(t, r.ReadFrame(frame))
+		err = r.ReadFrame(frame)
+		assert.True(t, errors.Is(err, ErrTruncatedFrame), "got %v", err)
+	}
+}
+
+func TestFieldError(t *testing.T) {
+	f := Fields{XResolution: {1, 2}}
+
+	_, err := f.Uint32(YResolution)
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, FieldError{Code: YResolution, Want: 4, Got: -1}, *fieldErr)
+	assert.EqualError(t, err, "field 'Y' not found")
+
+	_, err = f.Uint32(XResolution)
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, FieldError{Code: XResolution, Want: 4, Got: 2}, *fieldErr)
+	assert.EqualError(t, err, "field 'X' has length 2, expected 4")
+
+	_, err = f.String(DeviceName)
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, -1, fieldErr.Got)
+}
+
+func TestStrictValidFiles(t *testing.T) {
+	for _, filename := range []string{"v2.cptv"} {
+		r, err := NewFileReader(filename, Strict())
+		require.NoError(t, err)
+		count, err := r.FrameCount()
+		assert.NoError(t, err, filename)
+		assert.True(t, count > 0)
+		r.Close()
+	}
+
+	camera := new(TestCamera)
+	for _, scheme := range []byte{CompressionRaw, CompressionDelta, CompressionRice, CompressionBlockDelta, CompressionMED} {
+		cptvBytes := new(bytes.Buffer)
+		w := NewWriter(cptvBytes, camera, WithCompression(scheme))
+		background := makeTestFrame(camera)
+		require.NoError(t, w.WriteHeader(Header{BackgroundFrame: background}))
+		require.NoError(t, w.WriteFrame(makeOffsetFrame(camera, background)))
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(cptvBytes, Strict())
+		require.NoError(t, err)
+		count, err := r.FrameCount()
+		assert.NoError(t, err, "scheme %d", scheme)
+		assert.Equal(t, 2, count)
+	}
+}
+
+func TestStrictHeader(t *testing.T) {
+	camera := new(TestCamera)
+	cptvBytes := new(bytes.Buffer)
+	b := NewBuilder(cptvBytes)
+	fields := NewFieldWriter()
+	fields.Uint32(XResolution, uint32(camera.ResX()))
+	fields.Uint32(YResolution, uint32(camera.ResY()))
+	fields.Uint8(Compression, CompressionDelta)
+	require.NoError(t, b.WriteHeader(fields))
+	require.NoError(t, b.Close())
+
+	// The missing timestamp is only a problem in strict mode.
+	_, err := NewReader(bytes.NewReader(cptvBytes.Bytes()))
+	require.NoError(t, err)
+	_, err = NewReader(bytes.NewReader(cptvBytes.Bytes()), Strict())
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, Timestamp, fieldErr.Code)
+}
+
+func TestStrictResolution(t *testing.T) {
+	for _, res := range [][2]uint32{{0, 120}, {65535, 65535}, {MaxFramePixels + 1, 1}} {
+		cptvBytes := new(bytes.Buffer)
+		b := NewBuilder(cptvBytes)
+		fields := NewFieldWriter()
+		fields.Timestamp(Timestamp, time.Now())
+		fields.Uint32(XResolution, res[0])
+		fields.Uint32(YResolution, res[1])
+		fields.Uint8(Compression, CompressionDelta)
+		require.NoError(t, b.WriteHeader(fields))
+		require.NoError(t, b.Close())
+
+		_, err := NewReader(bytes.NewReader(cptvBytes.Bytes()), Strict())
+		assert.True(t, errors.Is(err, ErrBadResolution), "%dx%d: %v", res[0], res[1], err)
+	}
+}
+
+func TestStrictFrames(t *testing.T) {
+	camera := new(TestCamera)
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	frame := makeTestFrame(camera)
+	require.NoError(t, w.WriteFrame(frame))
+
+	// Add a frame with an invalid bit width and one with the wrong
+	// frame size.
+	for _, bitWidth := range []uint8{40, 3} {
+		fields := NewFieldWriter()
+		fields.Uint32(TimeOn, 1)
+		fields.Uint32(LastFFCTime, 0)
+		fields.Float32(TempC, 0)
+		fields.Float32(LastFFCTempC, 0)
+		fields.Uint8(BitWidth, bitWidth)
+		fields.Uint32(FrameSize, 10)
+		require.NoError(t, w.bldr.WriteFrame(fields, make([]byte, 10)))
+	}
+
+	// A frame missing a compulsory field.
+	fields := NewFieldWriter()
+	fields.Uint8(BitWidth, 3)
+	fields.Uint32(FrameSize, 0)
+	require.NoError(t, w.bldr.WriteFrame(fields, nil))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes, Strict())
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+	err = r.ReadFrame(frameD)
+	assert.True(t, errors.Is(err, ErrCorruptFrame))
+	assert.EqualError(t, err, "corrupt frame: bit width 40")
+	err = r.ReadFrame(frameD)
+	assert.True(t, errors.Is(err, ErrCorruptFrame))
+	err = r.ReadFrame(frameD)
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, TimeOn, fieldErr.Code)
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	_, err := gw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func gunzipBytes(t *testing.T, data []byte) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(gr)
+	require.NoError(t, err)
+	return out
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Trim writes the frames of the recording in r from startFrame up to
+// (but not including) endFrame to a new recording in w. Frames are
+// numbered as for Reader.Position. endFrame may be past the end of
+// the recording, in which case the rest of the recording is kept. The
+// background frame, if present, is always kept.
+//
+// The frames are decoded and compressed again, with the same
+// compression scheme, so the new recording starts with a frame that
+// can be decoded on its own. The header's Timestamp is moved forward
+// to the time of the first kept frame and PreviewSecs is reduced by
+// the time trimmed from the start. Frame checksums and the footer are
+// kept if the recording has them, but keyframes and any signature
+// aren't (see Convert).
+func Trim(r io.Reader, w io.Writer, startFrame, endFrame int) error {
+	if startFrame < 0 || endFrame <= startFrame {
+		return fmt.Errorf("invalid frame range %d-%d", startFrame, endFrame)
+	}
+	reader, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	header, err := reader.Header()
+	if err != nil {
+		return err
+	}
+
+	// Read up to the first kept frame, noting how far into the
+	// recording it is.
+	frame := reader.EmptyFrame()
+	var firstTimeOn time.Duration
+	timed := false
+	for n := 0; n <= startFrame; n++ {
+		if err := reader.ReadFrame(frame); err == io.EOF {
+			return fmt.Errorf("recording only has %d frames", n)
+		} else if err != nil {
+			return err
+		}
+		if !frame.Status.BackgroundFrame && !timed {
+			firstTimeOn = frame.Status.TimeOn
+			timed = true
+		}
+	}
+	if timed {
+		offset := frame.Status.TimeOn - firstTimeOn
+		if reader.Version() < 2 {
+			// Time on isn't recorded.
+			offset = time.Duration(startFrame) * time.Second / time.Duration(reader.FPS())
+		}
+		trimHeader(&header, offset)
+	}
+
+	writer := NewWriter(w, reader, copyOptions(reader)...)
+	keepSettings(writer, reader)
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	for n := startFrame; n < endFrame; n++ {
+		if n > startFrame {
+			err := reader.ReadFrame(frame)
+			keepSettings(writer, reader)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if frame.Status.BackgroundFrame && header.BackgroundFrame != nil {
+			continue
+		}
+		if err := writer.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// trimHeader adjusts a header for a recording which has had offset
+// trimmed from its start.
+func trimHeader(h *Header, offset time.Duration) {
+	if !h.Timestamp.IsZero() {
+		h.Timestamp = h.Timestamp.Add(offset)
+	}
+	preview := time.Duration(h.PreviewSecs)*time.Second - offset
+	if preview > 0 {
+		// Round up so that all of the remaining preview is covered.
+		h.PreviewSecs = int((preview + time.Second - 1) / time.Second)
+	} else {
+		h.PreviewSecs = 0
+	}
+}
+
+// Concat joins the recordings read from readers into a single
+// recording written to w, for example to rejoin a recording split by
+// a device restart. The recordings must all have the same
+// resolution.
+//
+// The header, including the background frame, is taken from the first
+// recording and background frames in the other recordings are
+// dropped. The camera's clock restarts along with the device, so the
+// frame times (Telemetry.TimeOn and LastFFCTime) of each recording
+// after the first are shifted to continue on from the previous
+// recording, one frame period after its last frame. The first frame of
+// each recording after the first is
+// written as a keyframe so that each part can still be decoded
+// independently, which requires CPTV version 3 when there is more
+// than one recording; no other keyframes are written. The compression
+// scheme of the first recording is used throughout. Frame checksums
+// are written from the first recording which has them onwards and a
+// footer is written if any of the recordings has one, but signatures
+// are dropped.
+func Concat(w io.Writer, readers ...io.Reader) error {
+	if len(readers) == 0 {
+		return errors.New("no recordings to concatenate")
+	}
+
+	// Check all the recordings before writing anything.
+	cptvReaders := make([]*Reader, len(readers))
+	longFields := false
+	for i, r := range readers {
+		reader, err := NewReader(r)
+		if err != nil {
+			return fmt.Errorf("recording %d: %w", i+1, err)
+		}
+		if i > 0 {
+			first := cptvReaders[0]
+			if reader.ResX() != first.ResX() || reader.ResY() != first.ResY() {
+				return fmt.Errorf("recording %d is %dx%d but recording 1 is %dx%d",
+					i+1, reader.ResX(), reader.ResY(), first.ResX(), first.ResY())
+			}
+		}
+		if reader.Version() >= int(longFieldsVersion) {
+			longFields = true
+		}
+		cptvReaders[i] = reader
+	}
+
+	first := cptvReaders[0]
+	header, err := first.Header()
+	if err != nil {
+		return fmt.Errorf("recording 1: %w", err)
+	}
+	opts := copyOptions(first)
+	if longFields {
+		opts = append(opts, WithLongFields())
+	}
+	writer := NewWriter(w, first, opts...)
+	if len(cptvReaders) > 1 {
+		writer.enableKeyframes()
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+
+	var period time.Duration
+	if header.FPS > 0 {
+		period = time.Second / time.Duration(header.FPS)
+	}
+	var lastTimeOn, lastGap, offset time.Duration
+	frame := first.EmptyFrame()
+	for i, reader := range cptvReaders {
+		if i > 0 {
+			writer.nextKeyframe = true
+		}
+		shifted := i == 0
+		for {
+			err := reader.ReadFrame(frame)
+			keepSettings(writer, reader)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("recording %d: %w", i+1, err)
+			}
+			if frame.Status.BackgroundFrame && (i > 0 || header.BackgroundFrame != nil) {
+				continue
+			}
+			if !frame.Status.BackgroundFrame {
+				if !shifted {
+					gap := period
+					if gap == 0 {
+						// Use the time between the previous
+						// recording's last two frames instead.
+						gap = lastGap
+					}
+					offset = lastTimeOn + gap - frame.Status.TimeOn
+					shifted = true
+				}
+				frame.Status.TimeOn += offset
+				if frame.Status.LastFFCTime != 0 {
+					frame.Status.LastFFCTime += offset
+				}
+				lastGap = frame.Status.TimeOn - lastTimeOn
+				lastTimeOn = frame.Status.TimeOn
+			}
+			if err := writer.WriteFrame(frame); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Close()
+}
+
+// copyOptions returns the Writer options required to write the
+// recording being read by r without losing information.
+func copyOptions(r *Reader) []WriterOption {
+	opts := []WriterOption{WithCompression(compressionScheme(r.header))}
+	if r.Version() >= int(longFieldsVersion) {
+		opts = append(opts, WithLongFields())
+	}
+	return opts
+}
+
+// keepSettings enables the settings of the recording being read by r
+// which can only be seen as its frames are read: frame checksums, once
+// a frame with a checksum has been read, and the footer, once the end
+// of the recording has been reached. It is called after each frame is
+// read.
+func keepSettings(w *Writer, r *Reader) {
+	if _, err := r.frameFields.Uint32(FrameCRC); err == nil {
+		w.checksums = true
+	}
+	if r.parser.Section(FooterSection) != nil {
+		w.footer = true
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrim(t *testing.T) {
+	camera := new(TestCamera)
+	ts := time.Date(2020, 5, 1, 3, 0, 0, 0, time.UTC)
+	rec := writeTrimRecording(t, camera, Header{Timestamp: ts, PreviewSecs: 5}, false)
+
+	// The frames are 100ms apart so 2.5s is trimmed from the start.
+	out := new(bytes.Buffer)
+	require.NoError(t, Trim(bytes.NewReader(rec.bytes), out, 25, 40))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ts.Add(2500*time.Millisecond), r.Timestamp().UTC())
+	assert.Equal(t, 3, r.PreviewSecs())
+	assertFrames(t, r, rec.frames[25:40])
+
+	// The end may be past the end of the recording.
+	out.Reset()
+	require.NoError(t, Trim(bytes.NewReader(rec.bytes), out, 55, 100))
+	r, err = NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 0, r.PreviewSecs())
+	assertFrames(t, r, rec.frames[55:])
+
+	assert.Error(t, Trim(bytes.NewReader(rec.bytes), out, 60, 100))
+	assert.Error(t, Trim(bytes.NewReader(rec.bytes), out, 10, 10))
+}
+
+func TestTrimBackground(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTrimRecording(t, camera, Header{PreviewSecs: 5}, true)
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Trim(bytes.NewReader(rec.bytes), out, 11, 21))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 4, r.PreviewSecs())
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, rec.frames[0], header.BackgroundFrame)
+	assertFrames(t, r, append(rec.frames[:1], rec.frames[11:21]...))
+}
+
+func TestConcat(t *testing.T) {
+	camera := new(TestCamera)
+	rec0 := writeTrimRecording(t, camera, Header{DeviceName: "first"}, true)
+	rec1 := writeTrimRecording(t, camera, Header{DeviceName: "second"}, true)
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Concat(out, bytes.NewReader(rec0.bytes), bytes.NewReader(rec1.bytes)))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "first", r.DeviceName())
+	frameD := r.EmptyFrame()
+	for _, frame := range rec0.frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	// The second background frame is dropped and the second
+	// recording starts with a keyframe. Its frame times continue on
+	// from the first recording.
+	offset := rec0.frames[len(rec0.frames)-1].Status.TimeOn + 100*time.Millisecond - rec1.frames[1].Status.TimeOn
+	for i, frame := range rec1.frames[1:] {
+		require.NoError(t, r.ReadFrame(frameD))
+		frame = frame.CreateCopy()
+		frame.Status.TimeOn += offset
+		assert.Equal(t, frame, frameD)
+		keyframe, _ := r.frameFields.Uint8(Keyframe)
+		assert.Equal(t, i == 0, keyframe == 1)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestConcatRestart(t *testing.T) {
+	camera := new(TestCamera)
+	var parts [][]byte
+	for p := 0; p < 2; p++ {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf, camera, WithFooter())
+		require.NoError(t, w.WriteHeader(Header{FPS: 10}))
+		frame := makeTestFrame(camera)
+		for i := 0; i < 20; i++ {
+			frame = makeOffsetFrame(camera, frame)
+			frame.Status.TimeOn = time.Duration(i) * 100 * time.Millisecond
+			require.NoError(t, w.WriteFrame(frame))
+		}
+		require.NoError(t, w.Close())
+		parts = append(parts, buf.Bytes())
+	}
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Concat(out, bytes.NewReader(parts[0]), bytes.NewReader(parts[1])))
+
+	r, err := NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	require.NoError(t, r.SeekTime(2500*time.Millisecond))
+	assert.Equal(t, 25, r.Position())
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, 2500*time.Millisecond, frameD.Status.TimeOn)
+
+	summary, err := Probe(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 40, summary.FrameCount)
+	assert.Equal(t, 4*time.Second, summary.Duration)
+	summary, err = scanSummary(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Second, summary.Duration)
+}
+
+func TestConcatResolution(t *testing.T) {
+	rec0 := writeTrimRecording(t, new(TestCamera), Header{}, false)
+	rec1 := writeTrimRecording(t, &smallCamera{}, Header{}, false)
+
+	out := new(bytes.Buffer)
+	err := Concat(out, bytes.NewReader(rec0.bytes), bytes.NewReader(rec1.bytes))
+	assert.EqualError(t, err, "recording 2 is 32x24 but recording 1 is 160x120")
+	assert.Equal(t, 0, out.Len())
+}
+
+type smallCamera struct{}
+
+func (*smallCamera) ResX() int { return 32 }
+func (*smallCamera) ResY() int { return 24 }
+func (*smallCamera) FPS() int  { return 9 }
+
+// writeTrimRecording writes a recording of 60 frames, 100ms apart,
+// optionally preceded by a background frame.
+func TestTrimConcatSettings(t *testing.T) {
+	camera := new(TestCamera)
+	rec := writeTrimRecording(t, camera, Header{}, false, WithFrameChecksums(), WithFooter())
+	plain := writeTrimRecording(t, camera, Header{}, false)
+
+	// Trimming keeps the frame checksums and the footer.
+	trimmed := new(bytes.Buffer)
+	require.NoError(t, Trim(bytes.NewReader(rec.bytes), trimmed, 10, 20))
+	result, err := Verify(bytes.NewReader(trimmed.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 10, result.Checksums)
+	summary, err := Probe(bytes.NewReader(trimmed.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 10, summary.FrameCount)
+
+	// Concatenating adds checksums from the first part which has
+	// them.
+	joined := new(bytes.Buffer)
+	require.NoError(t, Concat(joined, bytes.NewReader(plain.bytes), bytes.NewReader(rec.bytes)))
+	result, err = Verify(bytes.NewReader(joined.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 120, result.Frames)
+	assert.Equal(t, 60, result.Checksums)
+	summary, err = Probe(bytes.NewReader(joined.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 120, summary.FrameCount)
+}
+
+func writeTrimRecording(t *testing.T, camera cptvframe.CameraSpec, header Header, background bool, opts ...WriterOption) testRecording {
+	var rec testRecording
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, opts...)
+	if background {
+		header.BackgroundFrame = makeTestFrame(camera)
+		header.BackgroundFrame.Status.BackgroundFrame = true
+		rec.frames = append(rec.frames, header.BackgroundFrame)
+	}
+	require.NoError(t, w.WriteHeader(header))
+	frame := makeTestFrame(camera)
+	for i := 0; i < 60; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		frame.Pix[i%camera.ResY()][0] = uint16(i)
+		frame.Status.TimeOn = 10*time.Second + time.Duration(i)*100*time.Millisecond
+		require.NoError(t, w.WriteFrame(frame))
+		rec.frames = append(rec.frames, frame.CreateCopy())
+	}
+	require.NoError(t, w.Close())
+	rec.bytes = buf.Bytes()
+	return rec
+}
+
+func assertFrames(t *testing.T, r *Reader, frames []*cptvframe.Frame) {
+	frameD := r.EmptyFrame()
+	for _, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
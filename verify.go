@@ -0,0 +1,82 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"io"
+)
+
+// VerifyResult reports the outcome of Verify.
+type VerifyResult struct {
+	// Frames is the number of frames in the recording, including the
+	// background frame.
+	Frames int
+	// Checksums is the number of frames which have a checksum (see
+	// WithFrameChecksums).
+	Checksums int
+	// Damaged lists the frames which don't match their checksum or
+	// couldn't be decoded. Frames which only couldn't be decoded
+	// because they depend on a damaged frame aren't included.
+	Damaged []FrameProblem
+}
+
+// FrameProblem describes a damaged frame.
+type FrameProblem struct {
+	// Frame is the position of the frame in the recording, counting
+	// from 0 (including the background frame).
+	Frame int
+	Err   error
+}
+
+// OK returns true if no damaged frames were found.
+func (v *VerifyResult) OK() bool {
+	return len(v.Damaged) == 0
+}
+
+// Verify reads all of a CPTV recording, checking each frame's
+// checksum (if it has one) and that it can be decoded. The gzip
+// checksum for the whole recording is also checked.
+//
+// Damaged frames are reported in the result. An error is returned if
+// the recording couldn't be read to the end, for example because it
+// is truncated or fails the gzip checksum; the result then covers the
+// frames read before the problem.
+func Verify(r io.Reader) (VerifyResult, error) {
+	var result VerifyResult
+	reader, err := NewReader(r)
+	if err != nil {
+		return result, err
+	}
+	frame := reader.EmptyFrame()
+	for {
+		err := reader.readFrame(frame)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil && !reader.aligned {
+			return result, err
+		}
+		if _, crcErr := reader.frameFields.Uint32(FrameCRC); crcErr == nil {
+			result.Checksums++
+		}
+		if err != nil && err != errBrokenReference {
+			result.Damaged = append(result.Damaged, FrameProblem{
+				Frame: result.Frames,
+				Err:   err,
+			})
+		}
+		result.Frames++
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 The Cacophony Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cptv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	camera := new(TestCamera)
+	frames, data := writeChecksummedRecording(t, camera, 10)
+
+	result, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, result.OK())
+	assert.Equal(t, 10, result.Frames)
+	assert.Equal(t, 10, result.Checksums)
+
+	r, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	for _, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestVerifyDamagedFrame(t *testing.T) {
+	camera := new(TestCamera)
+	frames, data := writeChecksummedRecording(t, camera, 10)
+
+	// Find where each frame ends.
+	r, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	var ends []int
+	for range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		ends = append(ends, int(r.parser.offset()))
+	}
+
+	// Change the last byte of frame 2's data.
+	raw := gunzipBytes(t, data)
+	raw[ends[2]-1] ^= 0x01
+	data = gzipBytes(t, raw)
+
+	result, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.False(t, result.OK())
+	assert.Equal(t, 10, result.Frames)
+	require.Len(t, result.Damaged, 1)
+	assert.Equal(t, 2, result.Damaged[0].Frame)
+	assert.True(t, errors.Is(result.Damaged[0].Err, ErrFrameChecksum))
+
+	// The frames following the damaged frame can't be read until the
+	// next keyframe.
+	r, err = NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	for i, frame := range frames {
+		err := r.ReadFrame(frameD)
+		switch {
+		case i == 2:
+			assert.True(t, errors.Is(err, ErrFrameChecksum))
+		case i > 2 && i < 5:
+			assert.Equal(t, errBrokenReference, err)
+		default:
+			require.NoError(t, err)
+			assert.Equal(t, frame, frameD)
+		}
+	}
+}
+
+func TestVerifyGzipChecksum(t *testing.T) {
+	camera := new(TestCamera)
+	_, data := writeChecksummedRecording(t, camera, 3)
+	data[len(data)-8] ^= 0xff
+
+	result, err := Verify(bytes.NewReader(data))
+	assert.Equal(t, gzip.ErrChecksum, err)
+	assert.Equal(t, 3, result.Frames)
+}
+
+func TestVerifyFile(t *testing.T) {
+	f, err := os.Open("v2.cptv")
+	require.NoError(t, err)
+	defer f.Close()
+	result, err := Verify(f)
+	require.NoError(t, err)
+	assert.True(t, result.OK())
+	assert.Equal(t, 0, result.Checksums)
+	assert.True(t, result.Frames > 0)
+}
+
+// writeChecksummedRecording writes a recording with frame checksums
+// and a keyframe every 5 frames.
+func writeChecksummedRecording(t *testing.T, camera cptvframe.CameraSpec, count int) ([]*cptvframe.Frame, []byte) {
+	var frames []*cptvframe.Frame
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, camera, WithFrameChecksums(), WithKeyframeInterval(5))
+	require.NoError(t, w.WriteHeader(Header{}))
+	frame := makeTestFrame(camera)
+	for i := 0; i < count; i++ {
+		frame = makeOffsetFrame(camera, frame)
+		require.NoError(t, w.WriteFrame(frame))
+		frames = append(frames, frame)
+	}
+	require.NoError(t, w.Close())
+	return frames, buf.Bytes()
+}
@@ -15,28 +15,91 @@
 package cptv
 
 import (
+	"crypto/ed25519"
+	"hash/crc32"
 	"io"
 	"time"
 
 	"github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/TheCacophonyProject/lepton3"
 )
 
 // NewWriter creates and returns a new Writer component
-func NewWriter(w io.Writer, c cptvframe.CameraSpec) *Writer {
-	return &Writer{
-		bldr: NewBuilder(w),
-		comp: NewCompressor(c),
+func NewWriter(w io.Writer, c cptvframe.CameraSpec, opts ...WriterOption) *Writer {
+	writer := &Writer{
+		bldr:   NewBuilder(w),
+		cols:   c.ResX(),
+		rows:   c.ResY(),
+		scheme: CompressionDelta,
 	}
+	for _, opt := range opts {
+		opt(writer)
+	}
+	if writer.scheme != CompressionDelta {
+		writer.bldr.requireVersion(compressionVersion)
+	}
+	codec, err := lookupCodec(writer.scheme)
+	if err != nil {
+		writer.err = err
+	} else {
+		writer.comp = codec.NewCompressor(c)
+	}
+	return writer
 }
 
-// Writer uses a Builder and Compressor to create CPTV files.
+// Writer uses a Builder and a FrameCompressor to create CPTV files.
 type Writer struct {
-	bldr *Builder
-	comp *Compressor
+	bldr       *Builder
+	comp       FrameCompressor
+	cols, rows int
+	fps        int
+	scheme     byte
+
+	// err records a problem with the Writer's options. It is
+	// returned by WriteHeader and WriteFrame.
+	err error
+
+	footer           bool
+	checksums        bool
+	signingKey       ed25519.PrivateKey
+	enc              *encryptingWriter
+	summary          summaryBuilder
+	keyframeInterval int
+	frames           int
+
+	// nextKeyframe forces the next frame to be written as a keyframe.
+	nextKeyframe bool
+}
+
+// WriterOption configures optional Writer behaviour. Options are
+// passed to NewWriter.
+type WriterOption func(*Writer)
+
+// WithFooter makes the Writer emit a footer when it is closed. The
+// footer records the number of frames and the duration of the
+// recording, allowing Probe to summarise the recording without
+// reading through it.
+//
+// The footer doesn't change the CPTV version, so readers which don't
+// know about footers (including versions of this package before
+// footers were added) read all the frames but then fail with an
+// unexpected section error instead of io.EOF. Only use WithFooter for
+// recordings which will be read by up to date readers.
+func WithFooter() WriterOption {
+	return func(w *Writer) {
+		w.footer = true
+	}
 }
 
 // Header defines the information stored in the header of a CPTV
 // file. All the fields are optional.
+//
+// Most fields are only written when they hold a non-zero value. Fields
+// marked in Present are written regardless, which allows a header
+// returned by Reader.Header to be written out again without losing
+// fields which were present but zero. As 0 is a valid altitude,
+// Altitude is only written if it is marked in Present or, when Present
+// is empty, if it isn't negative.
 type Header struct {
 	Timestamp       time.Time
 	DeviceName      string
@@ -54,100 +117,230 @@ type Header struct {
 	Brand           string
 	Model           string
 	BackgroundFrame *cptvframe.Frame
+	Present         HeaderMask
+
+	// Extra holds application defined fields, keyed by field
+	// code. Codes must be UserFieldMin or above so that they can't
+	// collide with fields defined by the CPTV specification.
+	Extra map[byte][]byte
+
+	// Reserved holds fields with codes below UserFieldMin which
+	// aren't understood by this package, for example fields from a
+	// newer version of the CPTV specification. They are written out
+	// again unchanged so that they survive when a recording is
+	// copied.
+	Reserved map[byte][]byte
+}
+
+// HeaderMask is a set of flags identifying Header fields.
+type HeaderMask uint32
+
+// Flags for each of the Header fields.
+const (
+	HasTimestamp HeaderMask = 1 << iota
+	HasDeviceName
+	HasDeviceID
+	HasCameraSerial
+	HasFirmware
+	HasPreviewSecs
+	HasMotionConfig
+	HasLatitude
+	HasLongitude
+	HasLocTimestamp
+	HasAltitude
+	HasAccuracy
+	HasFPS
+	HasBrand
+	HasModel
+	HasBackgroundFrame
+)
+
+// Has returns true if all the flags in fields are set.
+func (m HeaderMask) Has(fields HeaderMask) bool {
+	return m&fields == fields
+}
+
+// WithFrameChecksums makes the Writer store a CRC32 checksum of each
+// frame's data so that readers can detect damaged frames (see Verify).
+func WithFrameChecksums() WriterOption {
+	return func(w *Writer) {
+		w.checksums = true
+	}
+}
+
+// WithLongFields allows frames to have fields longer than 255 bytes
+// (see cptvframe.Frame.Extra). Files with long fields can't be read by
+// readers which only support CPTV version 2. Long header fields are
+// allowed without this option.
+func WithLongFields() WriterOption {
+	return func(w *Writer) {
+		w.bldr.EnableLongFields()
+	}
+}
+
+// WithCompression sets the compression scheme used for frames. The
+// default is CompressionDelta. The scheme must have been registered
+// (see RegisterCodec).
+//
+// Other schemes require CPTV version 3, as readers which only support
+// version 2 would decode the frames as CompressionDelta.
+func WithCompression(scheme byte) WriterOption {
+	return func(w *Writer) {
+		w.scheme = scheme
+	}
+}
+
+// WithKeyframeInterval makes the Writer write a keyframe every n
+// frames. Keyframes are compressed without reference to the frames
+// before them so readers can recover from a corrupted frame at the
+// next keyframe and can seek directly to keyframes. Keyframes aren't
+// written if n is less than 1 (the default).
+//
+// Keyframes require CPTV version 3, which readers which only support
+// version 2 can't read.
+func WithKeyframeInterval(n int) WriterOption {
+	return func(w *Writer) {
+		w.keyframeInterval = n
+		if n > 0 {
+			w.enableKeyframes()
+		}
+	}
+}
+
+// enableKeyframes allows keyframes to be written. It must be called
+// before WriteHeader.
+func (w *Writer) enableKeyframes() {
+	w.bldr.requireVersion(keyframesVersion)
 }
 
 // WriteHeader writes a CPTV file header
 func (w *Writer) WriteHeader(header Header) error {
+	if w.err != nil {
+		return w.err
+	}
+	fields, err := headerFields(header, w.cols, w.rows, w.scheme, header.BackgroundFrame != nil)
+	if err != nil {
+		return err
+	}
+	// Keep the frame rate that readers will see for the footer.
+	w.fps = header.FPS
+	if w.fps <= 0 {
+		w.fps = lepton3.FramesHz
+	}
+	if err := w.bldr.WriteHeader(fields); err != nil {
+		return err
+	}
+
+	if header.BackgroundFrame != nil {
+		header.BackgroundFrame.Status.BackgroundFrame = true
+		return w.WriteFrame(header.BackgroundFrame)
+	}
+	return nil
+}
+
+// headerFields encodes the fields of a CPTV header.
+func headerFields(header Header, cols, rows int, scheme byte, background bool) (*FieldWriter, error) {
 	t := header.Timestamp
 	if t.IsZero() {
 		t = time.Now()
 	}
 	fields := NewFieldWriter()
 	fields.Timestamp(Timestamp, t)
-	fields.Uint32(XResolution, uint32(w.comp.cols))
-	fields.Uint32(YResolution, uint32(w.comp.rows))
-	fields.Uint8(Compression, 1)
+	fields.Uint32(XResolution, uint32(cols))
+	fields.Uint32(YResolution, uint32(rows))
+	fields.Uint8(Compression, scheme)
 	fields.Uint32(CameraSerial, uint32(header.CameraSerial))
 
-	if len(header.DeviceName) > 0 {
+	if len(header.DeviceName) > 0 || header.Present.Has(HasDeviceName) {
 		err := fields.String(DeviceName, header.DeviceName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	if len(header.Firmware) > 0 {
+	if len(header.Firmware) > 0 || header.Present.Has(HasFirmware) {
 		err := fields.String(Firmware, header.Firmware)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	if len(header.Model) > 0 {
+	if len(header.Model) > 0 || header.Present.Has(HasModel) {
 		err := fields.String(Model, header.Model)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	if len(header.Brand) > 0 {
+	if len(header.Brand) > 0 || header.Present.Has(HasBrand) {
 		err := fields.String(Brand, header.Brand)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	if header.FPS > 0 {
+	if header.FPS > 0 || header.Present.Has(HasFPS) {
 		fields.Uint8(FPS, uint8(header.FPS))
 	}
 
-	if header.DeviceID > 0 {
+	if header.DeviceID > 0 || header.Present.Has(HasDeviceID) {
 		fields.Uint32(DeviceID, uint32(header.DeviceID))
 	}
 
 	fields.Uint8(PreviewSecs, uint8(header.PreviewSecs))
 
-	if len(header.MotionConfig) > 0 {
+	if len(header.MotionConfig) > 0 || header.Present.Has(HasMotionConfig) {
 		err := fields.String(MotionConfig, header.MotionConfig)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	// The location fields are optional. They are only written out if they have non-zero values.
-	if header.Latitude != 0.0 {
+	// The location fields are optional. They are only written out if
+	// they have non-zero values or are explicitly marked as present.
+	if header.Latitude != 0.0 || header.Present.Has(HasLatitude) {
 		fields.Float32(Latitude, header.Latitude)
 	}
-	if header.Longitude != 0.0 {
+	if header.Longitude != 0.0 || header.Present.Has(HasLongitude) {
 		fields.Float32(Longitude, header.Longitude)
 	}
-	if !header.LocTimestamp.IsZero() {
+	if !header.LocTimestamp.IsZero() || header.Present.Has(HasLocTimestamp) {
 		fields.Timestamp(LocTimestamp, header.LocTimestamp)
 	}
-	if header.Altitude >= 0.0 {
+	if header.Present.Has(HasAltitude) || header.Present == 0 && header.Altitude >= 0.0 {
 		fields.Float32(Altitude, header.Altitude)
 	}
-	if header.Accuracy != 0.0 {
+	if header.Accuracy != 0.0 || header.Present.Has(HasAccuracy) {
 		fields.Float32(Accuracy, header.Accuracy)
 	}
-	if header.BackgroundFrame != nil {
+	if background {
 		fields.Uint8(BackgroundFrame, 1)
 	}
-	err := w.bldr.WriteHeader(fields)
-	if err != nil {
-		return err
+	if err := writeExtraFields(fields, header.Extra); err != nil {
+		return nil, err
 	}
-
-	if header.BackgroundFrame != nil {
-		header.BackgroundFrame.Status.BackgroundFrame = true
-		return w.WriteFrame(header.BackgroundFrame)
+	if err := writeReservedFields(fields, header.Reserved, headerFieldCodes); err != nil {
+		return nil, err
 	}
-	return err
+	return fields, nil
 }
 
 // WriteFrame writes a CPTV frame
 func (w *Writer) WriteFrame(frame *cptvframe.Frame) error {
-	bitWidth, compFrame := w.comp.Next(frame)
+	if w.err != nil {
+		return w.err
+	}
+	keyframe := w.nextKeyframe || w.keyframeInterval > 0 && w.frames%w.keyframeInterval == 0
+	if keyframe && w.bldr.version < keyframesVersion {
+		if w.frames > 0 {
+			return errKeyframeVersion
+		}
+		// The first frame is always decoded as a keyframe.
+		keyframe = false
+	}
 	fields := NewFieldWriter()
+	if keyframe {
+		fields.Uint8(Keyframe, 1)
+	}
 	if frame.Status.BackgroundFrame {
 		fields.Uint8(BackgroundFrame, uint8(1))
 	} else {
@@ -155,15 +348,64 @@ func (w *Writer) WriteFrame(frame *cptvframe.Frame) error {
 		fields.Uint32(LastFFCTime, durationToMillis(frame.Status.LastFFCTime))
 		fields.Float32(TempC, float32(frame.Status.TempC))
 		fields.Float32(LastFFCTempC, float32(frame.Status.LastFFCTempC))
+		fields.Uint32(FrameCount, uint32(frame.Status.FrameCount))
+		fields.Uint16(FrameMean, frame.Status.FrameMean)
+		if frame.Status.FFCState != "" {
+			if err := fields.String(FFCState, frame.Status.FFCState); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeExtraFields(fields, frame.Extra); err != nil {
+		return err
 	}
+	if err := writeReservedFields(fields, frame.Reserved, frameFieldCodes); err != nil {
+		return err
+	}
+
+	if fields.long && w.bldr.version < longFieldsVersion {
+		return errLongFrameFields
+	}
+
+	// Only compress the frame once it's known that it can be written
+	// so that the compressor's state matches what readers will see.
+	if keyframe {
+		w.comp.Reset()
+	}
+	bitWidth, compFrame := w.comp.Next(frame)
 	fields.Uint8(BitWidth, uint8(bitWidth))
 	fields.Uint32(FrameSize, uint32(len(compFrame)))
-	return w.bldr.WriteFrame(fields, compFrame)
+	if w.checksums {
+		fields.Uint32(FrameCRC, crc32.ChecksumIEEE(compFrame))
+	}
+	if err := w.bldr.WriteFrame(fields, compFrame); err != nil {
+		return err
+	}
+	w.summary.add(&frame.Status, true)
+	w.frames++
+	w.nextKeyframe = false
+	return nil
 }
 
 // Close closes the CPTV file
 func (w *Writer) Close() error {
-	return w.bldr.Close()
+	if w.signingKey != nil {
+		if err := w.writeSignature(); err != nil {
+			return err
+		}
+	}
+	if err := w.bldr.Close(); err != nil {
+		return err
+	}
+	if w.footer {
+		if err := w.bldr.WriteFooter(footerFields(w.summary.finish(w.fps))); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
 }
 
 func durationToMillis(d time.Duration) uint32 {
@@ -16,10 +16,15 @@ package cptv
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/TheCacophonyProject/go-cptv/cptvframe"
 	"github.com/TheCacophonyProject/lepton3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -173,6 +178,247 @@ func TestFrameRoundTrip(t *testing.T) {
 	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
 }
 
+func TestFrameTelemetryRoundTrip(t *testing.T) {
+	camera := new(TestCamera)
+	frame0 := makeTestFrame(camera)
+	frame0.Status.TimeOn = 60 * time.Second
+	frame0.Status.FrameCount = 1234
+	frame0.Status.FrameMean = 3100
+	frame0.Status.FFCState = lepton3.FFCComplete
+
+	frame1 := makeOffsetFrame(camera, frame0)
+	frame1.Status.FrameCount = 1235
+	frame1.Status.FrameMean = 3102
+	frame1.Status.FFCState = ""
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	require.NoError(t, w.WriteFrame(frame0))
+	require.NoError(t, w.WriteFrame(frame1))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame0, frameD)
+	assert.Equal(t, 1234, frameD.Status.FrameCount)
+	assert.Equal(t, uint16(3100), frameD.Status.FrameMean)
+	assert.Equal(t, lepton3.FFCComplete, frameD.Status.FFCState)
+
+	// The FFC state from the previous frame isn't carried over.
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame1, frameD)
+	assert.Equal(t, "", frameD.Status.FFCState)
+}
+
+func TestExtraFields(t *testing.T) {
+	camera := new(TestCamera)
+	frame0 := makeTestFrame(camera)
+	frame0.Extra = map[byte][]byte{
+		UserFieldMin: {42},
+		0xff:         []byte("score=0.93"),
+	}
+	frame1 := makeOffsetFrame(camera, frame0)
+	frame1.Extra = nil
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{
+		Extra: map[byte][]byte{0x90: []byte("trap-12")},
+	}))
+	require.NoError(t, w.WriteFrame(frame0))
+	require.NoError(t, w.WriteFrame(frame1))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, map[byte][]byte{0x90: []byte("trap-12")}, header.Extra)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame0, frameD)
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame1, frameD)
+	assert.Nil(t, frameD.Extra)
+}
+
+func TestExtraFieldsReserved(t *testing.T) {
+	camera := new(TestCamera)
+	w := NewWriter(new(bytes.Buffer), camera)
+	err := w.WriteHeader(Header{Extra: map[byte][]byte{'q': nil}})
+	assert.EqualError(t, err, "extra field code 113 is reserved (codes from 128 up may be used)")
+
+	require.NoError(t, w.WriteHeader(Header{}))
+	frame := makeTestFrame(camera)
+	frame.Extra = map[byte][]byte{TimeOn: {1, 2, 3, 4}}
+	assert.Error(t, w.WriteFrame(frame))
+	frame.Extra = nil
+	frame.Reserved = map[byte][]byte{TimeOn: {1, 2, 3, 4}}
+	assert.EqualError(t, w.WriteFrame(frame), "reserved field code 116 is already written by this package")
+	frame.Reserved = map[byte][]byte{UserFieldMin: nil}
+	assert.Error(t, w.WriteFrame(frame))
+}
+
+func TestUnknownFields(t *testing.T) {
+	camera := new(TestCamera)
+
+	// Fields from a future version of the spec are made available.
+	cptvBytes := new(bytes.Buffer)
+	b := NewBuilder(cptvBytes)
+	fields := NewFieldWriter()
+	fields.Uint32(XResolution, uint32(camera.ResX()))
+	fields.Uint32(YResolution, uint32(camera.ResY()))
+	fields.Uint16('q', 7)
+	require.NoError(t, b.WriteHeader(fields))
+	require.NoError(t, b.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, map[byte][]byte{'q': {7, 0}}, header.Reserved)
+	assert.Nil(t, header.Extra)
+
+	// They are kept when the header is written again.
+	out := new(bytes.Buffer)
+	w := NewWriter(out, camera)
+	require.NoError(t, w.WriteHeader(header))
+	require.NoError(t, w.Close())
+	r, err = NewReader(out)
+	require.NoError(t, err)
+	header, err = r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, map[byte][]byte{'q': {7, 0}}, header.Reserved)
+}
+
+func TestRawFields(t *testing.T) {
+	camera := new(TestCamera)
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42"}))
+	require.NoError(t, w.WriteFrame(makeTestFrame(camera)))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	header := r.HeaderFields()
+	assert.Equal(t, []byte("nz42"), header[DeviceName])
+	assert.Equal(t, camera.ResX(), header.ResX())
+	assert.Nil(t, r.FrameFields())
+
+	require.NoError(t, r.ReadFrame(r.EmptyFrame()))
+	fields := r.FrameFields()
+	bitWidth, err := fields.Uint8(BitWidth)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(14), bitWidth)
+	frameSize, err := fields.Uint32(FrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(33603), frameSize)
+}
+
+func TestLongFields(t *testing.T) {
+	camera := new(TestCamera)
+	motionConfig := strings.Repeat("threshold: 50\n", 100)
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{
+		MotionConfig: motionConfig,
+		Extra:        map[byte][]byte{0x80: bytes.Repeat([]byte{1}, 255)},
+	}))
+	frame := makeTestFrame(camera)
+	frame.Extra = map[byte][]byte{0x80: bytes.Repeat([]byte{2}, 70000)}
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Version())
+	assert.Equal(t, motionConfig, r.MotionConfig())
+	header, err := r.Header()
+	require.NoError(t, err)
+	assert.Len(t, header.Extra[0x80], 255)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+}
+
+func TestLongFrameFields(t *testing.T) {
+	camera := new(TestCamera)
+	frame := makeTestFrame(camera)
+	frame.Extra = map[byte][]byte{0x80: make([]byte, 300)}
+
+	// Version 2 is used unless long fields are needed.
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	assert.Error(t, w.WriteFrame(frame))
+	frame.Extra = nil
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, w.Close())
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Version())
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+
+	frame.Extra = map[byte][]byte{0x80: make([]byte, 300)}
+	cptvBytes.Reset()
+	w = NewWriter(cptvBytes, camera, WithLongFields())
+	require.NoError(t, w.WriteHeader(Header{}))
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, w.Close())
+	r, err = NewReader(cptvBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Version())
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+}
+
+func TestLongFieldLimit(t *testing.T) {
+	header := []byte{'C', 'P', 'T', 'V', 3, HeaderSection, 1, longFieldLen, 0, 0, 0, 0, DeviceName}
+
+	// Oversized fields are rejected before anything is allocated.
+	binary.LittleEndian.PutUint32(header[8:], 0xfffffff0)
+	_, err := NewReader(bytes.NewReader(gzipBytes(t, header)))
+	assert.True(t, errors.Is(err, ErrFieldTooLong), "%v", err)
+
+	// Truncated long fields fail.
+	binary.LittleEndian.PutUint32(header[8:], MaxFieldLen)
+	data := append(header, "nz42"...)
+	_, err = NewReader(bytes.NewReader(gzipBytes(t, data)))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	fields := NewFieldWriter()
+	assert.True(t, errors.Is(fields.Raw(DeviceName, make([]byte, MaxFieldLen+1)), ErrFieldTooLong))
+}
+
+func TestV2Field255(t *testing.T) {
+	camera := new(TestCamera)
+	name := strings.Repeat("x", 255)
+
+	// Version 2 files may have 255 byte fields.
+	cptvBytes := new(bytes.Buffer)
+	b := NewBuilder(cptvBytes)
+	fields := NewFieldWriter()
+	fields.Uint32(XResolution, uint32(camera.ResX()))
+	fields.Uint32(YResolution, uint32(camera.ResY()))
+	fields.data = append(fields.data, 255, DeviceName)
+	fields.data = append(fields.data, name...)
+	fields.fieldCount++
+	require.NoError(t, b.WriteHeader(fields))
+	require.NoError(t, b.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Version())
+	assert.Equal(t, name, r.DeviceName())
+}
+
 func TestBackgroundFrame(t *testing.T) {
 	tempC := float64(20)
 	ffcTemp := float64(25)
@@ -226,3 +472,298 @@ func TestBackgroundFrame(t *testing.T) {
 
 	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
 }
+
+func TestReaderHeader(t *testing.T) {
+	camera := new(TestCamera)
+	background := makeTestFrame(camera)
+	background.Status.BackgroundFrame = true
+	frame := makeOffsetFrame(camera, background)
+	frame.Status.TimeOn = 60 * time.Second
+
+	header := Header{
+		Timestamp:       time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC),
+		DeviceName:      "nz42",
+		DeviceID:        22,
+		PreviewSecs:     8,
+		MotionConfig:    "keep on movin",
+		Latitude:        -36.86667,
+		Longitude:       174.76667,
+		LocTimestamp:    time.Date(2019, 5, 20, 9, 8, 7, 0, time.UTC),
+		Brand:           "Dev",
+		Model:           "GP",
+		FPS:             camera.FPS(),
+		CameraSerial:    1234567890,
+		Firmware:        "1.2.3",
+		BackgroundFrame: background,
+		// Altitude and Accuracy are zero but should still be written.
+		Present: HasAltitude | HasAccuracy,
+	}
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(header))
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	h, err := r.Header()
+	require.NoError(t, err)
+
+	assert.Equal(t, header.Timestamp, h.Timestamp.UTC())
+	assert.Equal(t, header.LocTimestamp, h.LocTimestamp.UTC())
+	assert.Equal(t, "nz42", h.DeviceName)
+	assert.Equal(t, 22, h.DeviceID)
+	assert.Equal(t, 8, h.PreviewSecs)
+	assert.Equal(t, "keep on movin", h.MotionConfig)
+	assert.Equal(t, float32(-36.86667), h.Latitude)
+	assert.Equal(t, float32(174.76667), h.Longitude)
+	assert.Equal(t, "Dev", h.Brand)
+	assert.Equal(t, "GP", h.Model)
+	assert.Equal(t, camera.FPS(), h.FPS)
+	assert.Equal(t, 1234567890, h.CameraSerial)
+	assert.Equal(t, "1.2.3", h.Firmware)
+	assert.Equal(t, background, h.BackgroundFrame)
+	assert.True(t, h.Present.Has(HasAltitude|HasAccuracy|HasLatitude|HasBackgroundFrame))
+
+	// The background frame is still returned by ReadFrame.
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, background, frameD)
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frame, frameD)
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestSeaLevelAltitude(t *testing.T) {
+	camera := new(TestCamera)
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42"}))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	h, err := r.Header()
+	require.NoError(t, err)
+	assert.True(t, h.Present.Has(HasAltitude))
+	assert.Equal(t, float32(0), h.Altitude)
+}
+
+func TestReaderHeaderAbsentFields(t *testing.T) {
+	camera := new(TestCamera)
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera)
+	require.NoError(t, w.WriteHeader(Header{DeviceName: "nz42", Altitude: -1}))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	h, err := r.Header()
+	require.NoError(t, err)
+
+	assert.True(t, h.Present.Has(HasTimestamp|HasDeviceName|HasPreviewSecs|HasCameraSerial))
+	assert.False(t, h.Present.Has(HasLatitude))
+	assert.False(t, h.Present.Has(HasAltitude))
+	assert.Equal(t, float32(0), h.Altitude)
+	assert.False(t, h.Present.Has(HasBrand))
+	assert.False(t, h.Present.Has(HasBackgroundFrame))
+	assert.Nil(t, h.BackgroundFrame)
+
+	// Writing the header out again gives the same header back.
+	cptvBytes2 := new(bytes.Buffer)
+	w = NewWriter(cptvBytes2, camera)
+	require.NoError(t, w.WriteHeader(h))
+	require.NoError(t, w.Close())
+	r, err = NewReader(cptvBytes2)
+	require.NoError(t, err)
+	h2, err := r.Header()
+	require.NoError(t, err)
+	assert.Equal(t, h.Present, h2.Present)
+	assert.Equal(t, h.Timestamp, h2.Timestamp)
+}
+
+func TestKeyframes(t *testing.T) {
+	camera := new(TestCamera)
+	frames := make([]*cptvframe.Frame, 10)
+	frame := makeTestFrame(camera)
+	for i := range frames {
+		frame = makeOffsetFrame(camera, frame)
+		frames[i] = frame
+	}
+
+	cptvBytes := new(bytes.Buffer)
+	w := NewWriter(cptvBytes, camera, WithKeyframeInterval(4))
+	require.NoError(t, w.WriteHeader(Header{}))
+	for _, frame := range frames {
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+
+	// Check which frames were written as keyframes.
+	p, err := NewParser(bytes.NewReader(cptvBytes.Bytes()))
+	require.NoError(t, err)
+	_, err = p.Header()
+	require.NoError(t, err)
+	for i := range frames {
+		fields, fr, err := p.Frame()
+		require.NoError(t, err)
+		_, err = fields.Uint8(Keyframe)
+		assert.Equal(t, i%4 == 0, err == nil, "frame %d", i)
+		io.Copy(ioutil.Discard, fr)
+	}
+
+	r, err := NewReader(bytes.NewReader(cptvBytes.Bytes()))
+	require.NoError(t, err)
+	// Version 2 readers can't decode keyframes.
+	assert.Equal(t, int(keyframesVersion), r.Version())
+	frameD := r.EmptyFrame()
+	for _, frame := range frames {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+
+	// Keyframes can be seeked to directly.
+	require.NoError(t, r.SeekFrame(8))
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frames[8], frameD)
+	assert.True(t, r.index.entries[8].keyframe)
+	assert.Nil(t, r.index.entries[8].snapshot)
+}
+
+func TestKeyframeVersion(t *testing.T) {
+	camera := new(TestCamera)
+	frame := makeTestFrame(camera)
+
+	// Keyframes can't be written once a version 2 header has been
+	// written.
+	w := NewWriter(ioutil.Discard, camera)
+	require.NoError(t, w.WriteHeader(Header{}))
+	w.nextKeyframe = true
+	require.NoError(t, w.WriteFrame(frame))
+	w.nextKeyframe = true
+	assert.Equal(t, errKeyframeVersion, w.WriteFrame(frame))
+
+	// Without keyframes, recordings are still version 2.
+	cptvBytes := new(bytes.Buffer)
+	w = NewWriter(cptvBytes, camera, WithKeyframeInterval(0))
+	require.NoError(t, w.WriteHeader(Header{}))
+	require.NoError(t, w.WriteFrame(frame))
+	require.NoError(t, w.Close())
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Version())
+}
+
+func TestKeyframeRecovery(t *testing.T) {
+	camera := new(TestCamera)
+	frames := make([]*cptvframe.Frame, 6)
+	frame := makeTestFrame(camera)
+	for i := range frames {
+		frame = makeOffsetFrame(camera, frame)
+		frames[i] = frame
+	}
+
+	// Write frames with a keyframe every 3 frames, with the data for
+	// frame 1 lost.
+	cptvBytes := new(bytes.Buffer)
+	b := NewBuilder(cptvBytes)
+	header := NewFieldWriter()
+	header.Uint32(XResolution, uint32(camera.ResX()))
+	header.Uint32(YResolution, uint32(camera.ResY()))
+	require.NoError(t, b.WriteHeader(header))
+	comp := NewCompressor(camera)
+	for i, frame := range frames {
+		fields := NewFieldWriter()
+		if i%3 == 0 {
+			comp.Reset()
+			fields.Uint8(Keyframe, 1)
+		}
+		bitWidth, data := comp.Next(frame)
+		if i == 1 {
+			data = data[:2]
+		}
+		fields.Uint8(BitWidth, bitWidth)
+		fields.Uint32(FrameSize, uint32(len(data)))
+		require.NoError(t, b.WriteFrame(fields, data))
+	}
+	require.NoError(t, b.Close())
+
+	r, err := NewReader(cptvBytes)
+	require.NoError(t, err)
+	frameD := r.EmptyFrame()
+	require.NoError(t, r.ReadFrame(frameD))
+	assert.Equal(t, frames[0], frameD)
+	assert.Error(t, r.ReadFrame(frameD))
+	assert.Error(t, r.ReadFrame(frameD))
+	for _, frame := range frames[3:] {
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+	assert.Equal(t, io.EOF, r.ReadFrame(frameD))
+}
+
+func TestWriteField255(t *testing.T) {
+	camera := new(TestCamera)
+	name := strings.Repeat("x", 255)
+	frame := makeTestFrame(camera)
+	frame.Extra = map[byte][]byte{0x80: bytes.Repeat([]byte{3}, 255)}
+
+	// 255 byte fields are written inline in version 2 files, and as
+	// long fields in version 3 files.
+	for _, opts := range [][]WriterOption{nil, {WithLongFields()}} {
+		cptvBytes := new(bytes.Buffer)
+		w := NewWriter(cptvBytes, camera, opts...)
+		require.NoError(t, w.WriteHeader(Header{DeviceName: name}))
+		require.NoError(t, w.WriteFrame(frame))
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(cptvBytes)
+		require.NoError(t, err)
+		assert.Equal(t, 2+len(opts), r.Version())
+		assert.Equal(t, name, r.DeviceName())
+		frameD := r.EmptyFrame()
+		require.NoError(t, r.ReadFrame(frameD))
+		assert.Equal(t, frame, frameD)
+	}
+}
+
+func TestReadVersionFields(t *testing.T) {
+	fields := NewFieldWriter()
+	require.NoError(t, fields.String(DeviceName, strings.Repeat("x", 300)))
+	data, n := fields.Bytes()
+	section := append([]byte{byte(n)}, data...)
+
+	f, err := ReadVersionFields(bytes.NewReader(section), 3)
+	require.NoError(t, err)
+	assert.Len(t, f[DeviceName], 300)
+	_, err = ReadVersionFields(bytes.NewReader(section), 4)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion), "%v", err)
+}
+
+func TestTooManyFields(t *testing.T) {
+	camera := new(TestCamera)
+	header := Header{
+		Timestamp:       time.Now(),
+		DeviceName:      "nz42",
+		Model:           "model",
+		Brand:           "brand",
+		Firmware:        "firmware",
+		Present:         ^HeaderMask(0),
+		BackgroundFrame: makeTestFrame(camera),
+		Extra:           make(map[byte][]byte),
+		Reserved:        make(map[byte][]byte),
+	}
+	header.BackgroundFrame.Status.BackgroundFrame = true
+	for code := 0; code <= 0xff; code++ {
+		if code >= int(UserFieldMin) {
+			header.Extra[byte(code)] = []byte{1}
+		} else if !headerFieldCodes[byte(code)] {
+			header.Reserved[byte(code)] = []byte{1}
+		}
+	}
+
+	w := NewWriter(ioutil.Discard, camera)
+	err := w.WriteHeader(header)
+	assert.True(t, errors.Is(err, ErrTooManyFields), "%v", err)
+}